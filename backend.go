@@ -2,11 +2,22 @@ package exoscale
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	vaultsdkver "github.com/hashicorp/vault/sdk/version"
 
@@ -16,19 +27,56 @@ import (
 	"github.com/exoscale/vault-plugin-auth-exoscale/version"
 )
 
+const bootstrapSecretUserDataMarker = "#vault-bootstrap-secret-sha256:"
+
+// instanceStatesDown are the states of instances that are, or are about to
+// be, stopped or destroyed.
+var instanceStatesDown = []string{"destroyed", "destroying", "expunging", "stopped", "stopping"}
+
 var backendHelp = `
 The Exoscale auth backend for Vault allows Exoscale Compute Instance Pool
 members to authenticate to a Vault server.
 `
 
 type exoscaleClient interface {
+	GetAntiAffinityGroup(context.Context, string, string) (*egoscale.AntiAffinityGroup, error)
+	GetDeployTarget(context.Context, string, string) (*egoscale.DeployTarget, error)
+	GetElasticIP(context.Context, string, string) (*egoscale.ElasticIP, error)
 	GetInstance(context.Context, string, string) (*egoscale.Instance, error)
 	GetInstancePool(context.Context, string, string) (*egoscale.InstancePool, error)
+	GetInstanceType(context.Context, string, string) (*egoscale.InstanceType, error)
+	GetPrivateNetwork(context.Context, string, string) (*egoscale.PrivateNetwork, error)
+	GetSKSCluster(context.Context, string, string) (*egoscale.SKSCluster, error)
+	GetSKSClusterAuthorityCert(context.Context, string, *egoscale.SKSCluster, string) (string, error)
 	GetSecurityGroup(context.Context, string, string) (*egoscale.SecurityGroup, error)
+	GetSSHKey(context.Context, string, string) (*egoscale.SSHKey, error)
+	GetTemplate(context.Context, string, string) (*egoscale.Template, error)
+	ListElasticIPs(context.Context, string) ([]*egoscale.ElasticIP, error)
+	ListInstances(context.Context, string) ([]*egoscale.Instance, error)
+	ListPrivateNetworks(context.Context, string) ([]*egoscale.PrivateNetwork, error)
+	ListSKSClusters(context.Context, string) ([]*egoscale.SKSCluster, error)
 }
 
 type exoscaleBackend struct {
-	exo exoscaleClient
+	exo        exoscaleClient
+	httpClient *http.Client
+
+	accountClientsLock sync.Mutex
+	accountClients     map[string]exoscaleClient
+	celProgramsLock    sync.Mutex
+	celProgramsCache   map[string]*celProgramCacheEntry
+	instanceIPsLock    sync.Mutex
+	instanceIPsCache   map[string]*instanceLookupCacheEntry
+	instanceKeyLock    sync.Mutex
+	instanceNamesLock  sync.Mutex
+	instanceNamesCache map[string]*instanceLookupCacheEntry
+	nonceKeyLock       sync.Mutex
+	nonceKeyCache      []byte
+	secretIDLock       sync.Mutex
+	sksKeysLock        sync.Mutex
+	sksKeysCache       map[string]*sksClusterKeysCacheEntry
+	usedNoncesLock     sync.Mutex
+
 	*framework.Backend
 }
 
@@ -50,6 +98,16 @@ func (b *exoscaleBackend) config(ctx context.Context, storage logical.Storage) (
 	return &config, nil
 }
 
+// periodicFunc is invoked by Vault on a regular basis to purge the backend
+// storage from expired records.
+func (b *exoscaleBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if err := b.tidyUsedNonces(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	return b.tidyRoleSecretIDs(ctx, req.Storage)
+}
+
 func (b *exoscaleBackend) authRenew(
 	ctx context.Context,
 	req *logical.Request,
@@ -86,16 +144,32 @@ func (b *exoscaleBackend) authRenew(
 		return logical.ErrorResponse("role %q not found", roleName), nil
 	}
 
-	if _, err = b.auth(ctx, role, req, nil); err != nil {
-		b.Logger().Error(
-			err.Error(),
-			"client_remote_addr", req.Connection.RemoteAddr,
-		)
+	if role.AuthType == roleAuthTypeIAM {
+		err = b.renewIAM(ctx, role, req)
+	} else {
+		_, _, _, err = b.auth(ctx, role, req, nil)
+	}
+	if err == nil && role.KeyBinding {
+		err = b.checkInstanceKeyBinding(ctx, req)
+	}
+	if err != nil {
+		b.Logger().Error(err.Error(), authErrorLogArgs(req, roleName, err)...)
 
 		switch {
 		case errors.Is(err, errMissingField), errors.Is(err, errInvalidFieldValue):
 			return logical.ErrorResponse(err.Error()), nil
 
+		case errors.Is(err, errInstanceNotRunning):
+			// The token holder is told why the renewal is refused, as it
+			// won't succeed until the instance is running again. Vault drops
+			// the response of failed renewals, hence no error is returned.
+			return logical.ErrorResponse(err.Error()), nil
+
+		case errors.Is(err, errInstanceNotFound):
+			// Likewise, the renewal won't ever succeed once the instance
+			// has been destroyed.
+			return logical.ErrorResponse("instance no longer exists"), nil
+
 		case errors.Is(err, errAuthFailed):
 			return nil, logical.ErrPermissionDenied
 
@@ -117,64 +191,472 @@ func (b *exoscaleBackend) auth(
 	role *backendRole,
 	req *logical.Request,
 	data *framework.FieldData,
-) (*egoscale.Instance, error) {
-	var instanceID string
+) (*exoscaleAccount, *egoscale.Instance, *sksServiceAccount, error) {
+	var (
+		instanceID, instanceName, roleName string
+		account                            *exoscaleAccount
+		instance                           *egoscale.Instance
+	)
 
 	config, err := b.config(ctx, req.Storage)
 	if err != nil {
-		return nil, errors.New("backend is not configured")
+		return nil, nil, nil, errors.New("backend is not configured")
+	}
+
+	if role.AuthType == roleAuthTypeIAM {
+		return nil, nil, nil, fmt.Errorf("%w: role doesn't support instance authentication", errAuthFailed)
+	}
+
+	accounts, err := b.accounts(ctx, req.Storage, config, role)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	if data != nil {
 		// Initial login mode
 
-		param := authLoginParamInstance
+		roleParam, instanceParam := authLoginParamRole, authLoginParamInstance
 		// In AppRole-compatible mode, we expect `role`/`instance` parameters to be passed using
-		// the same name as in the AppRole authentication method (`role_id`/`secret_id`).
+		// the same name as in the AppRole authentication method (`role_id`/`secret_id`),
+		// unless the role requires actual secret IDs.
 		if config.AppRoleMode {
-			param = authLoginParamSecretID
+			roleParam = authLoginParamRoleID
+			if !role.BindSecretID {
+				instanceParam = authLoginParamSecretID
+			}
+		}
+
+		roleName = data.Get(roleParam).(string)
+		instanceID = data.Get(instanceParam).(string)
+		instanceName = data.Get(authLoginParamInstanceName).(string)
+
+		if instanceID != "" && instanceName != "" {
+			return nil, nil, nil, fmt.Errorf("%w: %q and %q parameters are mutually exclusive",
+				errInvalidFieldValue,
+				instanceParam,
+				authLoginParamInstanceName)
 		}
 
-		instanceID = data.Get(param).(string)
+		// If the client specifies the instance zone, only look it up there;
+		// otherwise, all configured zones are probed.
+		if zone := data.Get(authLoginParamZone).(string); zone != "" {
+			if accounts = accountsInZone(accounts, zone); len(accounts) == 0 {
+				return nil, nil, nil, fmt.Errorf("%w: %s: zone %q is not configured",
+					errInvalidFieldValue,
+					authLoginParamZone,
+					zone)
+			}
+		}
 	} else {
 		// Token renewal mode
 
+		roleName, _ = req.Auth.InternalData["role"].(string)
+
 		if v, ok := req.Auth.InternalData["instance_id"]; ok {
 			instanceID = v.(string)
 		} else {
-			return nil, fmt.Errorf(
+			return nil, nil, nil, fmt.Errorf(
 				"%w: instance_id information missing from token internal data",
 				errInternalError,
 			)
 		}
+
+		// Only check the account and zone the instance was found in upon
+		// login. Tokens issued before multi-account/zone support don't record
+		// them, which were necessarily the default ones.
+		accountName, _ := req.Auth.InternalData["account"].(string)
+		zone, _ := req.Auth.InternalData["zone"].(string)
+		if zone == "" {
+			zone = config.Zone
+		}
+
+		var account *exoscaleAccount
+		for _, a := range accounts {
+			if a.name == accountName {
+				account = a.inZone(zone)
+				break
+			}
+		}
+		if account == nil {
+			return nil, nil, nil, fmt.Errorf("%w: role is no longer bound to account %q",
+				errAuthFailed,
+				accountName)
+		}
+		accounts = []*exoscaleAccount{account}
 	}
 
-	if instanceID == "" {
-		return nil, fmt.Errorf("%w: %s", errMissingField, authLoginParamInstance)
+	clientIP, err := config.clientIP(req)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	ctx = exoapi.WithEndpoint(ctx, exoapi.NewReqEndpoint(config.APIEnvironment, config.Zone))
+	switch {
+	case instanceID != "":
+		// Instance ID supplied by the client, or stored in the token upon renewal.
 
-	instance, err := b.exo.GetInstance(ctx, config.Zone, instanceID)
-	if err != nil {
-		if errors.Is(err, exoapi.ErrNotFound) {
-			return nil, fmt.Errorf(
-				"%w: instance %s does not exist in zone %s",
-				errAuthFailed,
-				instanceID,
-				config.Zone,
+	case data == nil:
+		return nil, nil, nil, fmt.Errorf("%w: %s", errMissingField, authLoginParamInstance)
+
+	case instanceName != "":
+		var account *exoscaleAccount
+		if account, instanceID, err = b.resolveInstanceName(ctx, accounts, instanceName); err != nil {
+			return nil, nil, nil, err
+		}
+		accounts = []*exoscaleAccount{account}
+
+	default:
+		// No instance ID supplied by the client: look up the instance matching
+		// the client IP address.
+		if account, instance, err = b.discoverClientInstance(ctx, accounts, clientIP); err != nil {
+			return nil, nil, nil, err
+		}
+		instanceID = *instance.ID
+	}
+
+	if instance == nil {
+		if account, instance, err = b.getInstance(ctx, accounts, instanceID); err != nil {
+			if instanceName != "" && errors.Is(err, errAuthFailed) {
+				b.forgetInstanceName(accounts[0], accounts[0].zones[0], instanceName)
+			}
+			return nil, nil, nil, err
+		}
+	}
+
+	// The instance may have been renamed since its name was looked up.
+	if instanceName != "" && (instance.Name == nil || *instance.Name != instanceName) {
+		b.forgetInstanceName(account, account.zones[0], instanceName)
+		return nil, nil, nil, fmt.Errorf("%w: instance %s is no longer named %q",
+			errAuthFailed,
+			instanceID,
+			instanceName)
+	}
+
+	// Tokens issued to instances that have since been stopped or destroyed
+	// are not renewed, regardless of the role validator.
+	if data == nil && instance.State != nil && strutil.StrListContains(instanceStatesDown, *instance.State) {
+		return account, instance, nil, fmt.Errorf("%w: instance %s is %s",
+			errInstanceNotRunning,
+			instanceID,
+			*instance.State)
+	}
+
+	ctx = account.withEndpoint(ctx, account.zones[0])
+
+	if data != nil && role.BootstrapSecretSource != roleBootstrapSecretSourceNone {
+		if err := checkInstanceBootstrapSecret(
+			instance,
+			role,
+			data.Get(authLoginParamBootstrapSecret).(string),
+		); err != nil {
+			return account, instance, nil, err
+		}
+	}
+
+	var serviceAccount *sksServiceAccount
+	if role.SKSClusterID != "" {
+		if data != nil {
+			serviceAccount, err = b.checkSKSServiceAccount(
+				ctx,
+				account.exo,
+				role,
+				instance,
+				data.Get(authLoginParamServiceAccountToken).(string),
 			)
+			if err != nil {
+				return account, instance, nil, err
+			}
+		} else {
+			// The service account token is not available upon renewal: only
+			// the instance membership to the SKS cluster is checked again.
+			cluster, err := account.exo.GetSKSCluster(ctx, *instance.Zone, role.SKSClusterID)
+			if err != nil {
+				return account, instance, nil, fmt.Errorf("%w: unable to retrieve SKS cluster %q: %v", // nolint:errorlint
+					errInternalError,
+					role.SKSClusterID,
+					err)
+			}
+			if err := checkSKSNodepoolMembership(instance, cluster); err != nil {
+				return account, instance, nil, err
+			}
+
+			serviceAccount = &sksServiceAccount{}
+			serviceAccount.Namespace, _ = req.Auth.InternalData["sa_namespace"].(string)
+			serviceAccount.Name, _ = req.Auth.InternalData["sa_name"].(string)
+		}
+	}
+
+	if err := b.checkInstanceRole(
+		ctx,
+		account.exo,
+		clientIP,
+		instance,
+		serviceAccount,
+		roleName,
+		role,
+		config.apiConcurrency(),
+	); err != nil {
+		return account, instance, nil, err
+	}
+
+	// The secret ID is only checked here: it is consumed by the caller once
+	// all other checks have passed, so that failed login attempts don't
+	// exhaust its number of uses.
+	if data != nil && role.BindSecretID {
+		if err := b.checkRoleSecretID(
+			ctx,
+			req.Storage,
+			roleName,
+			data.Get(authLoginParamSecretID).(string),
+			instance,
+			false,
+		); err != nil {
+			return account, instance, nil, err
+		}
+	}
+
+	return account, instance, serviceAccount, nil
+}
+
+// checkInstanceBootstrapSecret verifies that the bootstrap secret supplied by
+// the client matches the SHA-256 hash set by the operator on the instance,
+// either in its user data or in a label depending on the role settings.
+func checkInstanceBootstrapSecret(instance *egoscale.Instance, role *backendRole, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("%w: %s", errMissingField, authLoginParamBootstrapSecret)
+	}
+
+	var expectedHash string
+	switch role.BootstrapSecretSource {
+	case roleBootstrapSecretSourceUserData:
+		if instance.UserData != nil {
+			expectedHash = bootstrapSecretHashFromUserData(*instance.UserData)
 		}
-		return nil, fmt.Errorf("%w: unable to retrieve Compute instance information: %v",
+
+	case roleBootstrapSecretSourceLabel:
+		if instance.Labels != nil {
+			expectedHash = (*instance.Labels)[role.BootstrapSecretLabel]
+		}
+
+	default:
+		return fmt.Errorf("%w: unsupported bootstrap secret source %q",
 			errInternalError,
-			err) // nolint:errorlint
+			role.BootstrapSecretSource)
 	}
 
-	if err := b.checkInstanceRole(ctx, req, instance, role); err != nil {
-		return instance, err
+	if expectedHash == "" {
+		return fmt.Errorf("%w: no bootstrap secret hash found for instance %s",
+			errAuthFailed,
+			*instance.ID)
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(
+		[]byte(hex.EncodeToString(hash[:])),
+		[]byte(strings.ToLower(expectedHash)),
+	) != 1 {
+		return fmt.Errorf("%w: bootstrap secret mismatch for instance %s",
+			errAuthFailed,
+			*instance.ID)
+	}
+
+	return nil
+}
+
+// renewIAM checks upon token renewal that the IAM access key the token has
+// been issued to still exists and still matches the role bindings. The backend
+// cannot replay the pre-signed login request, which has expired at this point:
+// the access key details are retrieved using the backend API credentials.
+func (b *exoscaleBackend) renewIAM(ctx context.Context, role *backendRole, req *logical.Request) error {
+	key, _ := req.Auth.InternalData["iam_access_key"].(string)
+	if key == "" {
+		return fmt.Errorf("%w: iam_access_key information missing from token internal data",
+			errInternalError)
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil || config == nil {
+		return errors.New("backend is not configured")
+	}
+
+	securityProvider, err := exoapi.NewSecurityProvider(config.APIKey, config.APISecret)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	accessKey, status, err := b.getIAMAccessKey(ctx, config, "/v2/access-key/"+url.PathEscape(key),
+		func(req *http.Request) error { return securityProvider.Intercept(ctx, req) })
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == http.StatusNotFound:
+		return fmt.Errorf("%w: IAM access key %s no longer exists", errAuthFailed, key)
+
+	case status != http.StatusOK:
+		return fmt.Errorf("%w: unable to retrieve IAM access key %s: unexpected response status %d %s",
+			errInternalError,
+			key,
+			status,
+			http.StatusText(status))
+	}
+
+	if accessKey.Key != key {
+		return fmt.Errorf("%w: IAM request response doesn't match access key %s", errAuthFailed, key)
+	}
+
+	return role.checkIAMAccessKey(accessKey)
+}
+
+// bindInstanceKey verifies that the client has signed a nonce issued by the
+// backend using the private key bound to the instance, binding the submitted
+// public key to the instance upon its first successful login (trust-on-first-use).
+// It returns the fingerprint of the instance public key.
+func (b *exoscaleBackend) bindInstanceKey(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	roleName string,
+	instance *egoscale.Instance,
+) (string, error) {
+	nonce := data.Get(authLoginParamNonce).(string)
+	if nonce == "" {
+		return "", fmt.Errorf("%w: %s", errMissingField, authLoginParamNonce)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(data.Get(authLoginParamSignature).(string))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errInvalidFieldValue, authLoginParamSignature)
+	}
+	if len(signature) == 0 {
+		return "", fmt.Errorf("%w: %s", errMissingField, authLoginParamSignature)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(data.Get(authLoginParamPublicKey).(string))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errInvalidFieldValue, authLoginParamPublicKey)
+	}
+
+	nonceExpires, err := b.checkNonce(ctx, req.Storage, nonce, *instance.ID)
+	if err != nil {
+		if errors.Is(err, logical.ErrReadOnly) {
+			return "", err
+		}
+		return "", fmt.Errorf("%w: %v", errAuthFailed, err) // nolint:errorlint
+	}
+
+	b.instanceKeyLock.Lock()
+	defer b.instanceKeyLock.Unlock()
+
+	key, err := b.instanceKey(ctx, req.Storage, *instance.ID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	if key != nil {
+		if len(publicKey) > 0 && subtle.ConstantTimeCompare(publicKey, key.PublicKey) != 1 {
+			return "", fmt.Errorf("%w: public key doesn't match the key bound to instance %s",
+				errAuthFailed,
+				*instance.ID)
+		}
+
+		if !key.Verify([]byte(nonce), signature) {
+			return "", fmt.Errorf("%w: invalid nonce signature for instance %s",
+				errAuthFailed,
+				*instance.ID)
+		}
+
+		if err := b.consumeNonce(ctx, req.Storage, nonce, nonceExpires); err != nil {
+			return "", err
+		}
+
+		return key.Fingerprint(), nil
+	}
+
+	if len(publicKey) == 0 {
+		return "", fmt.Errorf("%w: %s", errMissingField, authLoginParamPublicKey)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("%w: %s: expected an Ed25519 public key",
+			errInvalidFieldValue,
+			authLoginParamPublicKey)
+	}
+
+	key = &instanceKey{
+		PublicKey: publicKey,
+		Role:      roleName,
+		Zone:      *instance.Zone,
+		CreatedAt: time.Now(),
+	}
+
+	if !key.Verify([]byte(nonce), signature) {
+		return "", fmt.Errorf("%w: invalid nonce signature for instance %s",
+			errAuthFailed,
+			*instance.ID)
+	}
+
+	if err := b.consumeNonce(ctx, req.Storage, nonce, nonceExpires); err != nil {
+		return "", err
+	}
+
+	entry, err := logical.StorageEntryJSON(instanceKeyStoragePathPrefix+*instance.ID, key)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return "", fmt.Errorf("%w: unable to store instance key: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	b.Logger().Info(
+		"instance key bound",
+		"instance_id", *instance.ID,
+		"fingerprint", key.Fingerprint(),
+	)
+
+	return key.Fingerprint(), nil
+}
+
+// checkInstanceKeyBinding verifies during token renewal that the public key
+// bound to the instance at login time is still the one currently bound to it:
+// since Vault doesn't forward client-supplied data to the backend upon token
+// renewal, resetting or re-binding an instance key prevents the renewal of
+// the tokens previously issued to the instance.
+func (b *exoscaleBackend) checkInstanceKeyBinding(ctx context.Context, req *logical.Request) error {
+	instanceID, _ := req.Auth.InternalData["instance_id"].(string)
+
+	fingerprint, ok := req.Auth.InternalData["key_fingerprint"].(string)
+	if !ok {
+		return fmt.Errorf("%w: no key bound to token for instance %s", errAuthFailed, instanceID)
+	}
+
+	key, err := b.instanceKey(ctx, req.Storage, instanceID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.Fingerprint()), []byte(fingerprint)) != 1 {
+		return fmt.Errorf("%w: key bound to instance %s has changed", errAuthFailed, instanceID)
+	}
+
+	return nil
+}
+
+// bootstrapSecretHashFromUserData looks up the bootstrap secret hash marker
+// line in the instance user data, which the Exoscale API returns
+// base64-encoded.
+func bootstrapSecretHashFromUserData(userData string) string {
+	if decoded, err := base64.StdEncoding.DecodeString(userData); err == nil {
+		userData = string(decoded)
+	}
+
+	for _, line := range strings.Split(userData, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, bootstrapSecretUserDataMarker) {
+			return strings.TrimSpace(strings.TrimPrefix(line, bootstrapSecretUserDataMarker))
+		}
 	}
 
-	return instance, nil
+	return ""
 }
 
 func init() {
@@ -186,23 +668,34 @@ func init() {
 }
 
 func Factory(ctx context.Context, backendConfig *logical.BackendConfig) (logical.Backend, error) {
-	var backend exoscaleBackend
+	backend := exoscaleBackend{httpClient: &http.Client{}}
 
 	backend.Backend = &framework.Backend{
-		BackendType: logical.TypeCredential,
-		AuthRenew:   backend.authRenew,
-		Help:        backendHelp,
+		BackendType:  logical.TypeCredential,
+		AuthRenew:    backend.authRenew,
+		Invalidate:   backend.invalidate,
+		PeriodicFunc: backend.periodicFunc,
+		Help:         backendHelp,
 
 		Paths: []*framework.Path{
 			pathInfo(&backend),
 			pathConfig(&backend),
+			pathListAccounts(&backend),
+			pathAccount(&backend),
 			pathLogin(&backend),
+			pathLoginIAM(&backend),
 			pathListRoles(&backend),
 			pathRole(&backend),
+			pathRoleSecretID(&backend),
+			pathRoleSecretIDAccessor(&backend),
+			pathRoleTest(&backend),
+			pathNonce(&backend),
+			pathListInstanceKeys(&backend),
+			pathInstanceKey(&backend),
 		},
 
 		PathsSpecial: &logical.Paths{
-			Unauthenticated: []string{"login"},
+			Unauthenticated: []string{"login", "login/iam", "nonce"},
 		},
 	}
 
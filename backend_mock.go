@@ -12,6 +12,21 @@ type exoscaleClientMock struct {
 	mock.Mock
 }
 
+func (m *exoscaleClientMock) GetAntiAffinityGroup(ctx context.Context, zone, id string) (*egoscale.AntiAffinityGroup, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.AntiAffinityGroup), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetDeployTarget(ctx context.Context, zone, id string) (*egoscale.DeployTarget, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.DeployTarget), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetElasticIP(ctx context.Context, zone, id string) (*egoscale.ElasticIP, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.ElasticIP), args.Error(1)
+}
+
 func (m *exoscaleClientMock) GetInstance(ctx context.Context, zone, id string) (*egoscale.Instance, error) {
 	args := m.Called(ctx, zone, id)
 	return args.Get(0).(*egoscale.Instance), args.Error(1)
@@ -22,7 +37,62 @@ func (m *exoscaleClientMock) GetInstancePool(ctx context.Context, zone, id strin
 	return args.Get(0).(*egoscale.InstancePool), args.Error(1)
 }
 
+func (m *exoscaleClientMock) GetInstanceType(ctx context.Context, zone, id string) (*egoscale.InstanceType, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.InstanceType), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetPrivateNetwork(ctx context.Context, zone, id string) (*egoscale.PrivateNetwork, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.PrivateNetwork), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetSKSCluster(ctx context.Context, zone, id string) (*egoscale.SKSCluster, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.SKSCluster), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetSKSClusterAuthorityCert(
+	ctx context.Context,
+	zone string,
+	cluster *egoscale.SKSCluster,
+	authority string,
+) (string, error) {
+	args := m.Called(ctx, zone, cluster, authority)
+	return args.String(0), args.Error(1)
+}
+
 func (m *exoscaleClientMock) GetSecurityGroup(ctx context.Context, zone, id string) (*egoscale.SecurityGroup, error) {
 	args := m.Called(ctx, zone, id)
 	return args.Get(0).(*egoscale.SecurityGroup), args.Error(1)
 }
+
+func (m *exoscaleClientMock) GetSSHKey(ctx context.Context, zone, name string) (*egoscale.SSHKey, error) {
+	args := m.Called(ctx, zone, name)
+	return args.Get(0).(*egoscale.SSHKey), args.Error(1)
+}
+
+func (m *exoscaleClientMock) GetTemplate(ctx context.Context, zone, id string) (*egoscale.Template, error) {
+	args := m.Called(ctx, zone, id)
+	return args.Get(0).(*egoscale.Template), args.Error(1)
+}
+
+func (m *exoscaleClientMock) ListElasticIPs(ctx context.Context, zone string) ([]*egoscale.ElasticIP, error) {
+	args := m.Called(ctx, zone)
+	return args.Get(0).([]*egoscale.ElasticIP), args.Error(1)
+}
+
+func (m *exoscaleClientMock) ListInstances(ctx context.Context, zone string) ([]*egoscale.Instance, error) {
+	args := m.Called(ctx, zone)
+	return args.Get(0).([]*egoscale.Instance), args.Error(1)
+}
+
+func (m *exoscaleClientMock) ListPrivateNetworks(ctx context.Context, zone string) ([]*egoscale.PrivateNetwork, error) {
+	args := m.Called(ctx, zone)
+	return args.Get(0).([]*egoscale.PrivateNetwork), args.Error(1)
+}
+
+func (m *exoscaleClientMock) ListSKSClusters(ctx context.Context, zone string) ([]*egoscale.SKSCluster, error) {
+	args := m.Called(ctx, zone)
+	return args.Get(0).([]*egoscale.SKSCluster), args.Error(1)
+}
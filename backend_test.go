@@ -3,6 +3,7 @@ package exoscale
 import (
 	"context"
 	"math/rand"
+	"net/http"
 	"testing"
 	"time"
 
@@ -66,20 +67,66 @@ func (ts *backendTestSuite) TestBackendAuthRenew() {
 	}{
 		{
 			name: "fail_permission_denied",
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			internalData: map[string]interface{}{
+				"account":     "lorem-ipsum",
+				"instance_id": testInstanceID,
+				"role":        testRoleName,
+				"zone":        testZone,
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail_instance_not_found",
 			setupFunc: func(ts *backendTestSuite) {
 				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
 					On("GetInstance", mock.Anything, testZone, testInstanceID).
 					Return(new(egoscale.Instance), exoapi.ErrNotFound)
 			},
 			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
-				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+				status, err := logical.RespondErrorCommon(
+					&logical.Request{Operation: logical.RenewOperation},
+					res,
+					err)
+				ts.Require().Equal(http.StatusBadRequest, status)
+				ts.Require().EqualError(err, "instance no longer exists")
+			},
+			internalData: map[string]interface{}{
+				"instance_id": testInstanceID,
+				"role":        testRoleName,
+				"zone":        testZone,
+			},
+		},
+		{
+			name: "fail_instance_stopped",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:       &testInstanceCreated,
+						ID:              &testInstanceID,
+						PublicIPAddress: &testInstanceIPAddress,
+						State:           &testInstanceStateStopped,
+						Zone:            &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				// Vault only relays the response of renewals not returning
+				// an error to the token holder.
+				status, err := logical.RespondErrorCommon(
+					&logical.Request{Operation: logical.RenewOperation},
+					res,
+					err)
+				ts.Require().Equal(http.StatusBadRequest, status)
+				ts.Require().Contains(err.Error(), "stopped")
 			},
 			internalData: map[string]interface{}{
 				"instance_id": testInstanceID,
 				"role":        testRoleName,
 				"zone":        testZone,
 			},
-			wantErr: true,
 		},
 		{
 			name: "ok",
@@ -102,26 +149,6 @@ func (ts *backendTestSuite) TestBackendAuthRenew() {
 						TemplateID:       &testInstanceTemplateID,
 						Zone:             &testZone,
 					}, nil)
-
-				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
-					On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
-					Return(&egoscale.InstancePool{
-						ElasticIPIDs:   nil,
-						ID:             &testInstancePoolID,
-						InstanceIDs:    &[]string{testInstanceID},
-						InstanceTypeID: &testInstanceTypeID,
-						Name:           &testInstancePoolName,
-						State:          &testInstanceState,
-						TemplateID:     &testInstanceTemplateID,
-						Zone:           &testZone,
-					}, nil)
-
-				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
-					On("GetSecurityGroup", mock.Anything, testZone, testInstanceSecurityGroupID).
-					Return(&egoscale.SecurityGroup{
-						ID:   &testInstanceSecurityGroupID,
-						Name: &testInstanceSecurityGroupName,
-					}, nil)
 			},
 			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, _ error) {
 				ts.Require().Equal(
@@ -131,6 +158,10 @@ func (ts *backendTestSuite) TestBackendAuthRenew() {
 						"zone":        testZone,
 					},
 					res.Auth.InternalData)
+
+				// The default validator doesn't reference the instance
+				// related resources.
+				ts.assertDefaultValidatorCalls()
 			},
 			internalData: map[string]interface{}{
 				"instance_id": testInstanceID,
@@ -144,6 +175,7 @@ func (ts *backendTestSuite) TestBackendAuthRenew() {
 		ts.T().Run(tt.name, func(t *testing.T) {
 			// Reset the Exoscale client mock calls stack between test cases
 			ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).ExpectedCalls = nil
+			ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).Calls = nil
 
 			ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
 			ts.storeEntry(roleStoragePathPrefix+testRoleName, testRole)
@@ -170,6 +202,17 @@ func (ts *backendTestSuite) TestBackendAuthRenew() {
 	}
 }
 
+// assertDefaultValidatorCalls asserts that the Exoscale API calls retrieving
+// resources not referenced by the default role validator have not been
+// performed.
+func (ts *backendTestSuite) assertDefaultValidatorCalls() {
+	exo := ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock)
+	exo.AssertNotCalled(ts.T(), "GetInstancePool", mock.Anything, testZone, testInstancePoolID)
+	exo.AssertNotCalled(ts.T(), "GetSecurityGroup", mock.Anything, testZone, testInstanceSecurityGroupID)
+	exo.AssertNotCalled(ts.T(), "GetInstanceType", mock.Anything, testZone, testInstanceTypeID)
+	exo.AssertNotCalled(ts.T(), "GetTemplate", mock.Anything, testZone, testInstanceTemplateID)
+}
+
 func (ts *backendTestSuite) randomID() string {
 	id, err := uuid.NewV4()
 	if err != nil {
@@ -0,0 +1,252 @@
+package exoscale
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+const (
+	celIPTypeName   = "net.IP"
+	celCIDRTypeName = "net.IPNet"
+)
+
+var (
+	celIPDeclType   = decls.NewAbstractType(celIPTypeName)
+	celCIDRDeclType = decls.NewAbstractType(celCIDRTypeName)
+
+	celIPType   = types.NewTypeValue(celIPTypeName)
+	celCIDRType = types.NewTypeValue(celCIDRTypeName)
+)
+
+// celIP represents an IP address value in CEL expressions.
+type celIP struct {
+	net.IP
+}
+
+func (v celIP) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	if reflect.TypeOf(v.IP).AssignableTo(typeDesc) {
+		return v.IP, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type conversion from %s to %v", celIPTypeName, typeDesc)
+}
+
+func (v celIP) ConvertToType(typeValue ref.Type) ref.Val {
+	switch typeValue {
+	case types.StringType:
+		return types.String(v.IP.String())
+	case types.TypeType:
+		return celIPType
+	}
+
+	return types.NewErr("type conversion error from %s to %s", celIPTypeName, typeValue.TypeName())
+}
+
+func (v celIP) Equal(other ref.Val) ref.Val {
+	o, ok := other.(celIP)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(other)
+	}
+
+	return types.Bool(v.IP.Equal(o.IP))
+}
+
+func (v celIP) Type() ref.Type {
+	return celIPType
+}
+
+func (v celIP) Value() interface{} {
+	return v.IP
+}
+
+// celCIDR represents an IP network value in CEL expressions.
+type celCIDR struct {
+	*net.IPNet
+}
+
+func (v celCIDR) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	if reflect.TypeOf(v.IPNet).AssignableTo(typeDesc) {
+		return v.IPNet, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type conversion from %s to %v", celCIDRTypeName, typeDesc)
+}
+
+func (v celCIDR) ConvertToType(typeValue ref.Type) ref.Val {
+	switch typeValue {
+	case types.StringType:
+		return types.String(v.IPNet.String())
+	case types.TypeType:
+		return celCIDRType
+	}
+
+	return types.NewErr("type conversion error from %s to %s", celCIDRTypeName, typeValue.TypeName())
+}
+
+func (v celCIDR) Equal(other ref.Val) ref.Val {
+	o, ok := other.(celCIDR)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(other)
+	}
+
+	return types.Bool(v.IPNet.String() == o.IPNet.String())
+}
+
+func (v celCIDR) Type() ref.Type {
+	return celCIDRType
+}
+
+func (v celCIDR) Value() interface{} {
+	return v.IPNet
+}
+
+// celNetworkLib is a CEL library providing functions to handle IP addresses
+// and networks in role validator expressions:
+//
+//	ip(string) -> net.IP
+//	cidr(string) -> net.IPNet
+//	string(net.IP) -> string
+//	string(net.IPNet) -> string
+//	<net.IPNet>.contains(net.IP) -> bool
+//	<net.IP>.is_ipv4() -> bool
+//	<net.IP>.is_ipv6() -> bool
+//	<string>.matches_glob(string) -> bool
+type celNetworkLib struct{}
+
+func (celNetworkLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Declarations(
+			decls.NewFunction("ip",
+				decls.NewOverload("ip_string",
+					[]*exprpb.Type{decls.String}, celIPDeclType)),
+			decls.NewFunction("cidr",
+				decls.NewOverload("cidr_string",
+					[]*exprpb.Type{decls.String}, celCIDRDeclType)),
+			decls.NewFunction("string",
+				decls.NewOverload("ip_to_string",
+					[]*exprpb.Type{celIPDeclType}, decls.String),
+				decls.NewOverload("cidr_to_string",
+					[]*exprpb.Type{celCIDRDeclType}, decls.String)),
+			decls.NewFunction("contains",
+				decls.NewInstanceOverload("cidr_contains_ip",
+					[]*exprpb.Type{celCIDRDeclType, celIPDeclType}, decls.Bool)),
+			decls.NewFunction("is_ipv4",
+				decls.NewInstanceOverload("ip_is_ipv4",
+					[]*exprpb.Type{celIPDeclType}, decls.Bool)),
+			decls.NewFunction("is_ipv6",
+				decls.NewInstanceOverload("ip_is_ipv6",
+					[]*exprpb.Type{celIPDeclType}, decls.Bool)),
+			decls.NewFunction("matches_glob",
+				decls.NewInstanceOverload("string_matches_glob_string",
+					[]*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+		),
+	}
+}
+
+func (celNetworkLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Functions(
+			&functions.Overload{
+				Operator: "ip_string",
+				Unary: func(v ref.Val) ref.Val {
+					s, ok := v.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(v)
+					}
+					ip := net.ParseIP(string(s))
+					if ip == nil {
+						return types.NewErr("invalid IP address %q", string(s))
+					}
+					return celIP{ip}
+				},
+			},
+			&functions.Overload{
+				Operator: "cidr_string",
+				Unary: func(v ref.Val) ref.Val {
+					s, ok := v.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(v)
+					}
+					_, network, err := net.ParseCIDR(string(s))
+					if err != nil {
+						return types.NewErr("invalid CIDR %q", string(s))
+					}
+					return celCIDR{network}
+				},
+			},
+			&functions.Overload{
+				Operator: "ip_to_string",
+				Unary: func(v ref.Val) ref.Val {
+					return v.ConvertToType(types.StringType)
+				},
+			},
+			&functions.Overload{
+				Operator: "cidr_to_string",
+				Unary: func(v ref.Val) ref.Val {
+					return v.ConvertToType(types.StringType)
+				},
+			},
+			&functions.Overload{
+				Operator: "cidr_contains_ip",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					network, ok := lhs.(celCIDR)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(lhs)
+					}
+					ip, ok := rhs.(celIP)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(rhs)
+					}
+					return types.Bool(network.Contains(ip.IP))
+				},
+			},
+			&functions.Overload{
+				Operator: "ip_is_ipv4",
+				Unary: func(v ref.Val) ref.Val {
+					ip, ok := v.(celIP)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(v)
+					}
+					return types.Bool(ip.To4() != nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "ip_is_ipv6",
+				Unary: func(v ref.Val) ref.Val {
+					ip, ok := v.(celIP)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(v)
+					}
+					return types.Bool(ip.To4() == nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "string_matches_glob_string",
+				Binary: func(lhs, rhs ref.Val) ref.Val {
+					s, ok := lhs.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(lhs)
+					}
+					pattern, ok := rhs.(types.String)
+					if !ok {
+						return types.MaybeNoSuchOverloadErr(rhs)
+					}
+					matched, err := path.Match(string(pattern), string(s))
+					if err != nil {
+						return types.NewErr("invalid glob pattern %q: %v", string(pattern), err)
+					}
+					return types.Bool(matched)
+				},
+			},
+		),
+	}
+}
@@ -0,0 +1,83 @@
+package exoscale
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func (ts *backendTestSuite) TestCELFunctions() {
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{
+			name:       "cidr_contains_ipv4",
+			expression: `cidr("10.0.0.0/8").contains(ip(client_ip))`,
+			want:       true,
+		},
+		{
+			name:       "cidr_contains_ipv6",
+			expression: `cidr("2001:db8::/32").contains(ip("2001:DB8::1"))`,
+			want:       true,
+		},
+		{
+			name:       "cidr_not_contains",
+			expression: `cidr("192.168.0.0/16").contains(ip(client_ip))`,
+			want:       false,
+		},
+		{
+			name:       "ip_equality",
+			expression: `ip(client_ip) == ip("10.0.0.1") && string(ip("::FFFF:10.0.0.1")) == client_ip`,
+			want:       true,
+		},
+		{
+			name:       "ip_family",
+			expression: `ip(client_ip).is_ipv4() && !ip(client_ip).is_ipv6() && ip("2001:db8::1").is_ipv6()`,
+			want:       true,
+		},
+		{
+			name:       "matches_glob",
+			expression: `instance_name.matches_glob("web-*") && !instance_name.matches_glob("db-?")`,
+			want:       true,
+		},
+		{
+			name: "strings",
+			expression: `instance_name.substring(4) == "01" && instance_name.upperAscii() == "WEB-01" && ` +
+				`instance_name.split("-") == ["web", "01"] && instance_name.indexOf("-") == 3 && ` +
+				`" web ".trim().replace("w", "W") == "Web" && instance_name.charAt(0) == "w"`,
+			want: true,
+		},
+		{
+			name:       "fail_invalid_ip",
+			expression: `ip("not-an-ip").is_ipv4()`,
+			wantErr:    true,
+		},
+		{
+			name:       "fail_type_check",
+			expression: `cidr("10.0.0.0/8").contains(client_ip)`,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			p, err := buildCELProgram(tt.expression)
+			if err == nil {
+				var out ref.Val
+				out, _, err = p.program.Eval(map[string]interface{}{
+					roleValidatorVarClientIP:     "10.0.0.1",
+					roleValidatorVarInstanceName: "web-01",
+				})
+				if err == nil {
+					ts.Require().Equal(tt.want, out.Value())
+				}
+			}
+			if err != nil != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
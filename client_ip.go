@@ -0,0 +1,214 @@
+package exoscale
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	headerForwarded     = "Forwarded"
+	headerXForwardedFor = "X-Forwarded-For"
+)
+
+var errInvalidForwardedChain = fmt.Errorf("%w: invalid forwarded chain", errAuthFailed)
+
+// clientIP returns the IP address of the Vault client issuing the request. If
+// the request originates from one of the configured trusted proxies, the
+// client IP address is looked up in the X-Forwarded-For/Forwarded request
+// headers (which must be configured as passthrough request headers on the
+// auth method mount): the chain of forwarding hops is walked from the closest
+// to the farthest, and the first hop not belonging to a trusted proxy is the
+// client. Malformed or inconsistent chains are rejected.
+//
+// Vault doesn't pass request headers through upon token renewal: renewals
+// coming from a trusted proxy without forwarding headers use the client IP
+// address recorded in the token upon login, or the proxy address for tokens
+// not recording any.
+func (c *backendConfig) clientIP(req *logical.Request) (string, error) {
+	if req.Connection == nil {
+		return "", fmt.Errorf("%w: request connection information missing", errInternalError)
+	}
+
+	remoteIP := net.ParseIP(req.Connection.RemoteAddr)
+	if remoteIP == nil {
+		return canonicalIP(req.Connection.RemoteAddr), nil
+	}
+
+	trustedProxies, err := parseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	if !ipInNetworks(remoteIP, trustedProxies) {
+		return remoteIP.String(), nil
+	}
+
+	var chains [][]net.IP
+
+	if values := requestHeader(req, headerXForwardedFor); len(values) > 0 {
+		chain, err := parseXForwardedFor(values)
+		if err != nil {
+			return "", err
+		}
+		chains = append(chains, chain)
+	}
+
+	if values := requestHeader(req, headerForwarded); len(values) > 0 {
+		chain, err := parseForwarded(values)
+		if err != nil {
+			return "", err
+		}
+		chains = append(chains, chain)
+	}
+
+	if len(chains) == 0 && req.Operation == logical.RenewOperation && req.Auth != nil {
+		if ip, ok := req.Auth.InternalData["client_ip"].(string); ok && ip != "" {
+			return ip, nil
+		}
+		return remoteIP.String(), nil
+	}
+
+	if len(chains) == 0 {
+		return "", fmt.Errorf("%w: no client address forwarded by trusted proxy %s",
+			errInvalidForwardedChain,
+			remoteIP)
+	}
+
+	var clientIP net.IP
+	for _, chain := range chains {
+		ip := clientIPFromChain(chain, trustedProxies)
+		if clientIP != nil && !clientIP.Equal(ip) {
+			return "", fmt.Errorf("%w: %s and %s headers disagree",
+				errInvalidForwardedChain,
+				headerXForwardedFor,
+				headerForwarded)
+		}
+		clientIP = ip
+	}
+
+	return clientIP.String(), nil
+}
+
+// clientIPFromChain returns the first address of the forwarding chain (ordered
+// from the farthest to the closest hop) that doesn't belong to a trusted
+// proxy, walking the chain backwards. If all hops are trusted, the farthest
+// one is returned.
+func clientIPFromChain(chain []net.IP, trustedProxies []*net.IPNet) net.IP {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !ipInNetworks(chain[i], trustedProxies) {
+			return chain[i]
+		}
+	}
+
+	return chain[0]
+}
+
+// parseXForwardedFor parses the values of X-Forwarded-For headers.
+func parseXForwardedFor(values []string) ([]net.IP, error) {
+	chain := make([]net.IP, 0)
+
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			ip := parseForwardedNode(strings.TrimSpace(hop))
+			if ip == nil {
+				return nil, fmt.Errorf("%w: invalid %s hop %q",
+					errInvalidForwardedChain,
+					headerXForwardedFor,
+					hop)
+			}
+			chain = append(chain, ip)
+		}
+	}
+
+	return chain, nil
+}
+
+// parseForwarded parses the "for" parameters of Forwarded headers (RFC 7239).
+// Obfuscated or unknown node identifiers are rejected.
+func parseForwarded(values []string) ([]net.IP, error) {
+	chain := make([]net.IP, 0)
+
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var ip net.IP
+
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+					continue
+				}
+
+				if ip = parseForwardedNode(strings.Trim(kv[1], `"`)); ip == nil {
+					return nil, fmt.Errorf("%w: invalid %s node %q",
+						errInvalidForwardedChain,
+						headerForwarded,
+						kv[1])
+				}
+			}
+
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %s element without node %q",
+					errInvalidForwardedChain,
+					headerForwarded,
+					element)
+			}
+			chain = append(chain, ip)
+		}
+	}
+
+	return chain, nil
+}
+
+// parseForwardedNode parses a forwarding hop node address, optionally
+// including a port (e.g. "192.0.2.1", "192.0.2.1:1234", "[2001:db8::1]:1234").
+func parseForwardedNode(node string) net.IP {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+}
+
+// requestHeader returns the values of the request header name, if passed
+// through to the backend.
+func requestHeader(req *logical.Request, name string) []string {
+	for k, v := range req.Headers {
+		if http.CanonicalHeaderKey(k) == name {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks[i] = network
+	}
+
+	return networks, nil
+}
+
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/mock"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+func (ts *backendTestSuite) TestBackendConfigClientIP() {
+	config := backendConfig{TrustedProxies: []string{"10.0.0.0/24", "2001:db8:1::/64"}}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		headers      map[string][]string
+		internalData map[string]interface{}
+		renewal      bool
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:       "untrusted_remote_ignores_headers",
+			remoteAddr: "192.0.2.1",
+			headers:    map[string][]string{headerXForwardedFor: {"198.51.100.1"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "x_forwarded_for",
+			remoteAddr: "10.0.0.1",
+			headers:    map[string][]string{"x-forwarded-for": {"203.0.113.7, 198.51.100.1, 10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "2001:db8:1::1",
+			headers: map[string][]string{headerForwarded: {
+				`for="[2001:DB8:2::1]:4711";proto=https, for=10.0.0.2`,
+			}},
+			want: "2001:db8:2::1",
+		},
+		{
+			name:       "consistent_headers",
+			remoteAddr: "10.0.0.1",
+			headers: map[string][]string{
+				headerXForwardedFor: {"198.51.100.1"},
+				headerForwarded:     {"for=198.51.100.1"},
+			},
+			want: "198.51.100.1",
+		},
+		{
+			name:       "fail_missing_headers",
+			remoteAddr: "10.0.0.1",
+			wantErr:    true,
+		},
+		{
+			name:         "renewal_recorded_client",
+			remoteAddr:   "10.0.0.1",
+			internalData: map[string]interface{}{"client_ip": "198.51.100.1"},
+			renewal:      true,
+			want:         "198.51.100.1",
+		},
+		{
+			name:       "renewal_without_recorded_client",
+			remoteAddr: "10.0.0.1",
+			renewal:    true,
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "fail_inconsistent_headers",
+			remoteAddr: "10.0.0.1",
+			headers: map[string][]string{
+				headerXForwardedFor: {"198.51.100.1"},
+				headerForwarded:     {"for=198.51.100.2"},
+			},
+			wantErr: true,
+		},
+		{
+			name:       "fail_malformed_chain",
+			remoteAddr: "10.0.0.1",
+			headers:    map[string][]string{headerXForwardedFor: {"198.51.100.1, lolnope"}},
+			wantErr:    true,
+		},
+		{
+			name:       "fail_obfuscated_node",
+			remoteAddr: "10.0.0.1",
+			headers:    map[string][]string{headerForwarded: {"for=_hidden"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			req := &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Connection: &logical.Connection{RemoteAddr: tt.remoteAddr},
+				Headers:    tt.headers,
+			}
+			if tt.renewal {
+				req.Operation = logical.RenewOperation
+				req.Auth = &logical.Auth{InternalData: tt.internalData}
+			}
+
+			got, err := config.clientIP(req)
+			if err != nil != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				ts.Require().True(errors.Is(err, errAuthFailed))
+				return
+			}
+
+			ts.Require().Equal(tt.want, got)
+		})
+	}
+}
+
+func (ts *backendTestSuite) TestPathLoginTrustedProxyRenewal() {
+	testProxyIPAddress := "10.0.0.1"
+
+	ts.storeEntry(configStoragePath, &backendConfig{
+		Zone:           testZone,
+		TrustedProxies: []string{"10.0.0.0/24"},
+	})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testProxyIPAddress},
+		Headers:    map[string][]string{headerXForwardedFor: {testInstanceIPAddress.String()}},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+	ts.Require().Equal(testInstanceIPAddress.String(), res.Auth.InternalData["client_ip"])
+
+	// Vault doesn't pass the forwarding headers through upon renewal.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.RenewOperation,
+		Connection: &logical.Connection{RemoteAddr: testProxyIPAddress},
+		Auth:       &logical.Auth{InternalData: res.Auth.InternalData},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+}
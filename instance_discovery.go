@@ -0,0 +1,447 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+	exoapi "github.com/exoscale/egoscale/v2/api"
+)
+
+const instanceLookupCacheTTL = time.Minute
+
+// instanceLookupCacheEntry represents the result of an instance lookup by name
+// or IP address in a zone of an account.
+type instanceLookupCacheEntry struct {
+	ids     []string
+	fetched time.Time
+}
+
+// inZone returns a copy of the account restricted to the specified zone.
+func (a *exoscaleAccount) inZone(zone string) *exoscaleAccount {
+	account := *a
+	account.zones = []string{zone}
+	return &account
+}
+
+// withEndpoint returns a copy of the context targeting the account Exoscale
+// API environment in the specified zone.
+func (a *exoscaleAccount) withEndpoint(ctx context.Context, zone string) context.Context {
+	return exoapi.WithEndpoint(ctx, exoapi.NewReqEndpoint(a.apiEnvironment, zone))
+}
+
+// getInstance retrieves the Compute instance identified by id, probing the
+// zones of the specified accounts in order. The returned account is
+// restricted to the zone the instance has been found in.
+func (b *exoscaleBackend) getInstance(
+	ctx context.Context,
+	accounts []*exoscaleAccount,
+	id string,
+) (*exoscaleAccount, *egoscale.Instance, error) {
+	for _, account := range accounts {
+		for _, zone := range account.zones {
+			instance, err := account.exo.GetInstance(account.withEndpoint(ctx, zone), zone, id)
+			if err != nil {
+				if errors.Is(err, exoapi.ErrNotFound) {
+					continue
+				}
+				return nil, nil, fmt.Errorf("%w: unable to retrieve Compute instance information: %v",
+					errInternalError,
+					err) // nolint:errorlint
+			}
+
+			return account.inZone(zone), instance, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s in zone %s",
+		errInstanceNotFound,
+		id,
+		strings.Join(accountsZones(accounts), ", "))
+}
+
+// discoverClientInstance retrieves the Compute instance matching the client
+// IP address (see discoverInstance), along with the account restricted to the
+// zone it has been found in. As IP addresses can be reassigned to other
+// instances, an instance found using a cached lookup result must still own
+// the client IP address: otherwise, the stale result is evicted from the cache
+// and the lookup is performed again.
+func (b *exoscaleBackend) discoverClientInstance(
+	ctx context.Context,
+	accounts []*exoscaleAccount,
+	clientIP string,
+) (*exoscaleAccount, *egoscale.Instance, error) {
+	for attempt := 1; ; attempt++ {
+		account, instanceID, cached, err := b.discoverInstance(ctx, accounts, clientIP)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		zoneAccount, instance, err := b.getInstance(ctx, []*exoscaleAccount{account}, instanceID)
+		if err == nil && cached {
+			var owned bool
+			owned, err = instanceHasIP(account.withEndpoint(ctx, account.zones[0]), account, instance, clientIP)
+			if err == nil && !owned {
+				err = fmt.Errorf("%w: instance %s no longer has IP address %s", errAuthFailed, instanceID, clientIP)
+			}
+		}
+		if err == nil {
+			return zoneAccount, instance, nil
+		}
+
+		if !errors.Is(err, errAuthFailed) {
+			return nil, nil, err
+		}
+		b.forgetInstanceIP(account, account.zones[0], clientIP)
+		if !cached || attempt > 1 {
+			return nil, nil, err
+		}
+	}
+}
+
+// discoverInstance looks up the Compute instance whose public IP address
+// (IPv4 or IPv6), Elastic IP address or Private Network lease address matches
+// the client IP address in the zones of the specified accounts, and returns
+// its ID along with the account restricted to the zone it has been found in,
+// and whether the match comes from a cached lookup result. Since it is the
+// sole proof of identity of the client, the match must be unambiguous.
+func (b *exoscaleBackend) discoverInstance(
+	ctx context.Context,
+	accounts []*exoscaleAccount,
+	clientIP string,
+) (*exoscaleAccount, string, bool, error) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil, "", false, fmt.Errorf("%w: unable to discover instance: invalid client IP address %q",
+			errAuthFailed,
+			clientIP)
+	}
+
+	var (
+		// Instance ID -> account zone
+		matches = make(map[string]*exoscaleAccount)
+		cached  bool
+	)
+
+	for _, account := range accounts {
+		for _, zone := range account.zones {
+			ids, fromCache, err := b.discoverZoneInstances(account.withEndpoint(ctx, zone), account, zone, ip)
+			if err != nil {
+				return nil, "", false, err
+			}
+
+			for _, id := range ids {
+				matches[id] = account.inZone(zone)
+				cached = cached || fromCache
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, "", false, fmt.Errorf("%w: no instance found matching client IP address %s in zone %s",
+			errAuthFailed,
+			clientIP,
+			strings.Join(accountsZones(accounts), ", "))
+
+	case 1:
+		for id, account := range matches {
+			return account, id, cached, nil
+		}
+	}
+
+	ids := make([]string, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return nil, "", false, fmt.Errorf("%w: client IP address %s matches several instances (%s)",
+		errAuthFailed,
+		clientIP,
+		strings.Join(ids, ", "))
+}
+
+// discoverZoneInstances returns the IDs of the Compute instances of the
+// account zone having ip as public, Elastic or Private Network lease IP
+// address, and whether they come from the cache. Since a lookup requires
+// several Exoscale API calls and can be triggered by unauthenticated clients,
+// results are cached for a short period of time. The cache lock is not held
+// while querying the Exoscale API, so that a slow zone doesn't hold up the
+// lookups in other zones.
+func (b *exoscaleBackend) discoverZoneInstances(
+	ctx context.Context,
+	account *exoscaleAccount,
+	zone string,
+	ip net.IP,
+) ([]string, bool, error) {
+	cacheKey := instanceIPCacheKey(account, zone, ip)
+
+	b.instanceIPsLock.Lock()
+	entry, ok := b.instanceIPsCache[cacheKey]
+	b.instanceIPsLock.Unlock()
+	if ok && time.Since(entry.fetched) < instanceLookupCacheTTL {
+		return entry.ids, true, nil
+	}
+
+	matches := make(map[string]struct{})
+
+	instances, err := account.exo.ListInstances(ctx, zone)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: unable to list Compute instances: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	elasticIPs, err := account.exo.ListElasticIPs(ctx, zone)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: unable to list Elastic IPs: %v", errInternalError, err) // nolint:errorlint
+	}
+	matchingElasticIPs := make(map[string]struct{})
+	for _, elasticIP := range elasticIPs {
+		if elasticIP.IPAddress != nil && elasticIP.IPAddress.Equal(ip) {
+			matchingElasticIPs[*elasticIP.ID] = struct{}{}
+		}
+	}
+
+	for _, instance := range instances {
+		if (instance.PublicIPAddress != nil && instance.PublicIPAddress.Equal(ip)) ||
+			(instance.IPv6Address != nil && instance.IPv6Address.Equal(ip)) {
+			matches[*instance.ID] = struct{}{}
+			continue
+		}
+
+		if instance.ElasticIPIDs != nil {
+			for _, id := range *instance.ElasticIPIDs {
+				if _, ok := matchingElasticIPs[id]; ok {
+					matches[*instance.ID] = struct{}{}
+				}
+			}
+		}
+	}
+
+	privateNetworks, err := account.exo.ListPrivateNetworks(ctx, zone)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: unable to list Private Networks: %v", errInternalError, err) // nolint:errorlint
+	}
+	for _, item := range privateNetworks {
+		// Leases are only returned when retrieving a Private Network individually.
+		privateNetwork, err := account.exo.GetPrivateNetwork(ctx, zone, *item.ID)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: unable to retrieve Private Network %q: %v", // nolint:errorlint
+				errInternalError,
+				*item.ID,
+				err)
+		}
+
+		for _, lease := range privateNetwork.Leases {
+			if lease.IPAddress != nil && lease.IPAddress.Equal(ip) && lease.InstanceID != nil {
+				matches[*lease.InstanceID] = struct{}{}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+
+	b.instanceIPsLock.Lock()
+	if b.instanceIPsCache == nil {
+		b.instanceIPsCache = make(map[string]*instanceLookupCacheEntry)
+	}
+	b.instanceIPsCache[cacheKey] = &instanceLookupCacheEntry{ids: ids, fetched: time.Now()}
+	b.instanceIPsLock.Unlock()
+
+	return ids, false, nil
+}
+
+// instanceHasIP reports whether the client IP address is the public IP
+// address (IPv4 or IPv6), an Elastic IP address or a Private Network lease
+// address of the instance.
+func instanceHasIP(
+	ctx context.Context,
+	account *exoscaleAccount,
+	instance *egoscale.Instance,
+	clientIP string,
+) (bool, error) {
+	ip := net.ParseIP(clientIP)
+
+	if (instance.PublicIPAddress != nil && instance.PublicIPAddress.Equal(ip)) ||
+		(instance.IPv6Address != nil && instance.IPv6Address.Equal(ip)) {
+		return true, nil
+	}
+
+	if instance.ElasticIPIDs != nil {
+		for _, id := range *instance.ElasticIPIDs {
+			elasticIP, err := account.exo.GetElasticIP(ctx, *instance.Zone, id)
+			if err != nil {
+				return false, fmt.Errorf("%w: unable to retrieve Elastic IP %q: %v", // nolint:errorlint
+					errInternalError,
+					id,
+					err)
+			}
+			if elasticIP.IPAddress != nil && elasticIP.IPAddress.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	if instance.PrivateNetworkIDs != nil {
+		for _, id := range *instance.PrivateNetworkIDs {
+			privateNetwork, err := account.exo.GetPrivateNetwork(ctx, *instance.Zone, id)
+			if err != nil {
+				return false, fmt.Errorf("%w: unable to retrieve Private Network %q: %v", // nolint:errorlint
+					errInternalError,
+					id,
+					err)
+			}
+			for _, lease := range privateNetwork.Leases {
+				if lease.IPAddress != nil && lease.IPAddress.Equal(ip) &&
+					lease.InstanceID != nil && *lease.InstanceID == *instance.ID {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// forgetInstanceIP evicts an instance IP address lookup result from the cache.
+func (b *exoscaleBackend) forgetInstanceIP(account *exoscaleAccount, zone, clientIP string) {
+	b.instanceIPsLock.Lock()
+	defer b.instanceIPsLock.Unlock()
+
+	delete(b.instanceIPsCache, instanceIPCacheKey(account, zone, net.ParseIP(clientIP)))
+}
+
+func instanceIPCacheKey(account *exoscaleAccount, zone string, ip net.IP) string {
+	return account.name + "/" + zone + "/" + ip.String()
+}
+
+// resolveInstanceName returns the ID of the Compute instance named name in
+// the zones of the specified accounts, along with the account restricted to
+// the zone it has been found in. Since instance names are not unique, the
+// lookup fails if several instances bear the same name.
+func (b *exoscaleBackend) resolveInstanceName(
+	ctx context.Context,
+	accounts []*exoscaleAccount,
+	name string,
+) (*exoscaleAccount, string, error) {
+	var (
+		match *exoscaleAccount
+		id    string
+	)
+
+	for _, account := range accounts {
+		for _, zone := range account.zones {
+			ids, err := b.lookupInstanceName(account.withEndpoint(ctx, zone), account, zone, name)
+			if err != nil {
+				return nil, "", err
+			}
+
+			if len(ids) > 1 || (len(ids) == 1 && id != "") {
+				return nil, "", fmt.Errorf("%w: several instances named %q found", errAuthFailed, name)
+			}
+			if len(ids) == 1 {
+				match, id = account.inZone(zone), ids[0]
+			}
+		}
+	}
+
+	if id == "" {
+		return nil, "", fmt.Errorf("%w: no instance named %q found in zone %s",
+			errAuthFailed,
+			name,
+			strings.Join(accountsZones(accounts), ", "))
+	}
+
+	return match, id, nil
+}
+
+// lookupInstanceName returns the IDs of the Compute instances named name in
+// the specified zone of the account. Results are cached for a short period of
+// time to avoid listing instances upon every login. The cache lock is not held
+// while listing instances, so that a slow zone doesn't hold up the lookups in
+// other zones.
+func (b *exoscaleBackend) lookupInstanceName(
+	ctx context.Context,
+	account *exoscaleAccount,
+	zone string,
+	name string,
+) ([]string, error) {
+	cacheKey := instanceNameCacheKey(account, zone, name)
+
+	b.instanceNamesLock.Lock()
+	entry, ok := b.instanceNamesCache[cacheKey]
+	b.instanceNamesLock.Unlock()
+	if ok && time.Since(entry.fetched) < instanceLookupCacheTTL {
+		return entry.ids, nil
+	}
+
+	instances, err := account.exo.ListInstances(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to list Compute instances: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	ids := make([]string, 0)
+	for _, instance := range instances {
+		if instance.Name != nil && *instance.Name == name {
+			ids = append(ids, *instance.ID)
+		}
+	}
+
+	b.instanceNamesLock.Lock()
+	if b.instanceNamesCache == nil {
+		b.instanceNamesCache = make(map[string]*instanceLookupCacheEntry)
+	}
+	b.instanceNamesCache[cacheKey] = &instanceLookupCacheEntry{ids: ids, fetched: time.Now()}
+	b.instanceNamesLock.Unlock()
+
+	return ids, nil
+}
+
+// forgetInstanceName evicts an instance name lookup result from the cache.
+func (b *exoscaleBackend) forgetInstanceName(account *exoscaleAccount, zone, name string) {
+	b.instanceNamesLock.Lock()
+	defer b.instanceNamesLock.Unlock()
+
+	delete(b.instanceNamesCache, instanceNameCacheKey(account, zone, name))
+}
+
+func instanceNameCacheKey(account *exoscaleAccount, zone, name string) string {
+	return account.name + "/" + zone + "/" + name
+}
+
+// accountsZones returns the list of zones of the specified accounts.
+func accountsZones(accounts []*exoscaleAccount) []string {
+	zones := make([]string, 0)
+	for _, account := range accounts {
+		for _, zone := range account.zones {
+			if !strutil.StrListContains(zones, zone) {
+				zones = append(zones, zone)
+			}
+		}
+	}
+
+	return zones
+}
+
+// accountsInZone returns the specified accounts having zone configured,
+// restricted to this zone.
+func accountsInZone(accounts []*exoscaleAccount, zone string) []*exoscaleAccount {
+	zoneAccounts := make([]*exoscaleAccount, 0)
+	for _, account := range accounts {
+		if strutil.StrListContains(account.zones, zone) {
+			zoneAccounts = append(zoneAccounts, account.inZone(zone))
+		}
+	}
+
+	return zoneAccounts
+}
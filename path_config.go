@@ -3,21 +3,31 @@ package exoscale
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 
 	egoscale "github.com/exoscale/egoscale/v2"
+	exoapi "github.com/exoscale/egoscale/v2/api"
 )
 
 const (
 	configStoragePath       = "config"
+	configKeyAPIConcurrency = "api_concurrency"
 	configKeyAPIEnvironment = "api_environment"
 	configKeyAPIKey         = "api_key"
 	configKeyAPISecret      = "api_secret"
 	configKeyAppRoleMode    = "approle_mode"
+	configKeyIAMAPIEndpoint = "iam_api_endpoint"
+	configKeyIAMAudience    = "iam_audience"
+	configKeyTrustedProxies = "trusted_proxies"
 	configKeyZone           = "zone"
+	configKeyZones          = "zones"
 
+	defaultAPIConcurrency = 4
 	defaultAPIEnvironment = "api"
 )
 
@@ -27,6 +37,23 @@ var (
 This endpoint manages the configuration of the root Exoscale auth backend
 plugin, including the Exoscale API credentials enabling it to authenticate
 Vault clients using this authentication method.
+
+If Vault is running behind proxies or load balancers, their CIDR blocks can be
+specified using the "trusted_proxies" parameter: when receiving requests from
+those, the client IP address is read from the X-Forwarded-For or Forwarded
+request headers, which must be added to the auth method mount
+"passthrough_request_headers" setting.
+
+Vault clients running in several Exoscale zones can be authenticated by a
+single backend by specifying the list of zones using the "zones" parameter:
+upon login, instances are looked up in the zone specified by the client, or
+in every configured zone otherwise. The "zone" parameter specifies the
+default zone, and defaults to the first of the "zones" list.
+
+Upon login, the Exoscale API calls required to evaluate the role validator
+(e.g. to retrieve the Security Groups or the Instance Pool of the instance) are
+performed concurrently: the "api_concurrency" parameter sets the maximum number
+of calls in flight for a single login (default: 4).
 `
 )
 
@@ -34,6 +61,11 @@ func pathConfig(b *exoscaleBackend) *framework.Path {
 	p := &framework.Path{
 		Pattern: "config",
 		Fields: map[string]*framework.FieldSchema{
+			configKeyAPIConcurrency: {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of concurrent Exoscale API calls performed upon login",
+				Default:     defaultAPIConcurrency,
+			},
 			configKeyAPIEnvironment: {
 				Type:        framework.TypeString,
 				Description: "Exoscale API environment",
@@ -56,10 +88,27 @@ func pathConfig(b *exoscaleBackend) *framework.Path {
 				Default:     false,
 				Description: "Run in AppRole-compatible mode",
 			},
+			configKeyIAMAPIEndpoint: {
+				Type: framework.TypeString,
+				Description: "Exoscale API endpoint URL to replay IAM login requests against " +
+					"(default: derived from the API environment and zone)",
+			},
+			configKeyIAMAudience: {
+				Type:        framework.TypeString,
+				Description: "Value of the signed query string parameter required in IAM login requests (required for IAM logins)",
+			},
+			configKeyTrustedProxies: {
+				Type: framework.TypeCommaStringSlice,
+				Description: "List of CIDR blocks of trusted proxies, from which the client IP " +
+					"address is read from the X-Forwarded-For/Forwarded request headers",
+			},
 			configKeyZone: {
 				Type:        framework.TypeString,
-				Description: "Exoscale zone",
-				Required:    true,
+				Description: "Exoscale zone (default zone if several zones are configured)",
+			},
+			configKeyZones: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of Exoscale zones to look up instances in",
 			},
 		},
 
@@ -89,11 +138,16 @@ func (b *exoscaleBackend) pathConfigRead(
 	}
 
 	d := map[string]interface{}{
+		configKeyAPIConcurrency: config.apiConcurrency(),
 		configKeyAPIEnvironment: config.APIEnvironment,
 		configKeyAPIKey:         config.APIKey,
 		configKeyAPISecret:      config.APISecret,
 		configKeyAppRoleMode:    config.AppRoleMode,
+		configKeyIAMAPIEndpoint: config.IAMAPIEndpoint,
+		configKeyIAMAudience:    config.IAMAudience,
+		configKeyTrustedProxies: config.TrustedProxies,
 		configKeyZone:           config.Zone,
+		configKeyZones:          config.zones(),
 	}
 
 	return &logical.Response{
@@ -107,11 +161,37 @@ func (b *exoscaleBackend) pathConfigWrite(
 	data *framework.FieldData,
 ) (*logical.Response, error) {
 	config := backendConfig{
+		APIConcurrency: data.Get(configKeyAPIConcurrency).(int),
 		APIEnvironment: data.Get(configKeyAPIEnvironment).(string),
 		APIKey:         data.Get(configKeyAPIKey).(string),
 		APISecret:      data.Get(configKeyAPISecret).(string),
 		AppRoleMode:    data.Get(configKeyAppRoleMode).(bool),
+		IAMAPIEndpoint: data.Get(configKeyIAMAPIEndpoint).(string),
+		IAMAudience:    data.Get(configKeyIAMAudience).(string),
+		TrustedProxies: data.Get(configKeyTrustedProxies).([]string),
 		Zone:           data.Get(configKeyZone).(string),
+		Zones:          data.Get(configKeyZones).([]string),
+	}
+
+	if config.Zone == "" {
+		if len(config.Zones) == 0 {
+			return logical.ErrorResponse("%v: %s or %s", errMissingField, configKeyZone, configKeyZones), nil
+		}
+		config.Zone = config.Zones[0]
+	}
+
+	if config.APIConcurrency < 1 {
+		return logical.ErrorResponse("%v: %s must be greater than 0", errInvalidFieldValue, configKeyAPIConcurrency), nil
+	}
+
+	if config.IAMAPIEndpoint != "" {
+		if u, err := url.Parse(config.IAMAPIEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return logical.ErrorResponse("%v: %s", errInvalidFieldValue, configKeyIAMAPIEndpoint), nil
+		}
+	}
+
+	if _, err := parseCIDRs(config.TrustedProxies); err != nil {
+		return logical.ErrorResponse("%v: %s: %s", errInvalidFieldValue, configKeyTrustedProxies, err), nil
 	}
 
 	entry, err := logical.StorageEntryJSON(configStoragePath, config)
@@ -123,6 +203,12 @@ func (b *exoscaleBackend) pathConfigWrite(
 		return nil, err
 	}
 
+	// The login nonces key is generated along with the configuration, as
+	// performance standbys cannot write to the storage.
+	if _, err := b.nonceKey(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
 	exo, err := egoscale.NewClient(config.APIKey, config.APISecret)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize Exoscale client: %w", err)
@@ -137,9 +223,48 @@ func (b *exoscaleBackend) pathConfigWrite(
 }
 
 type backendConfig struct {
-	APIEnvironment string `json:"api_environment"`
-	APIKey         string `json:"api_key"`
-	APISecret      string `json:"api_secret"`
-	AppRoleMode    bool   `json:"approle_mode"`
-	Zone           string `json:"zone"`
+	APIConcurrency int      `json:"api_concurrency,omitempty"`
+	APIEnvironment string   `json:"api_environment"`
+	APIKey         string   `json:"api_key"`
+	APISecret      string   `json:"api_secret"`
+	AppRoleMode    bool     `json:"approle_mode"`
+	IAMAPIEndpoint string   `json:"iam_api_endpoint,omitempty"`
+	IAMAudience    string   `json:"iam_audience,omitempty"`
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	Zone           string   `json:"zone"`
+	Zones          []string `json:"zones,omitempty"`
+}
+
+// zones returns the list of zones configured in the backend, starting with
+// the default zone.
+func (c *backendConfig) zones() []string {
+	zones := []string{c.Zone}
+	for _, zone := range c.Zones {
+		if !strutil.StrListContains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones
+}
+
+// apiConcurrency returns the maximum number of concurrent Exoscale API calls
+// performed upon login.
+func (c *backendConfig) apiConcurrency() int {
+	if c.APIConcurrency < 1 {
+		return defaultAPIConcurrency
+	}
+
+	return c.APIConcurrency
+}
+
+// iamAPIEndpoint returns the base URL of the Exoscale API endpoint IAM
+// requests are performed against.
+func (c *backendConfig) iamAPIEndpoint() string {
+	if c.IAMAPIEndpoint != "" {
+		return strings.TrimSuffix(c.IAMAPIEndpoint, "/")
+	}
+
+	reqEndpoint := exoapi.NewReqEndpoint(c.APIEnvironment, c.Zone)
+	return "https://" + reqEndpoint.Host()
 }
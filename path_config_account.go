@@ -0,0 +1,323 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+const (
+	accountStoragePathPrefix = "config/account/"
+
+	accountKeyName = "name"
+)
+
+var (
+	pathListAccountsHelpSyn  = "List the configured Exoscale accounts"
+	pathListAccountsHelpDesc = `
+This endpoint returns a list of the Exoscale accounts configured in addition to
+the backend root configuration.
+`
+
+	pathAccountHelpSyn  = "Manage Exoscale accounts"
+	pathAccountHelpDesc = `
+This endpoint manages additional Exoscale accounts (organizations) the backend
+can authenticate Compute instances of, each with its own Exoscale API
+credentials, API environment and zones. Roles select the accounts their
+instances are looked up in using the "accounts" role parameter; roles not
+specifying any account use the account of the backend root configuration.
+`
+)
+
+// backendAccount represents an Exoscale account configured in addition to
+// the backend root configuration.
+type backendAccount struct {
+	APIEnvironment string   `json:"api_environment"`
+	APIKey         string   `json:"api_key"`
+	APISecret      string   `json:"api_secret"`
+	Zone           string   `json:"zone"`
+	Zones          []string `json:"zones,omitempty"`
+}
+
+// zones returns the list of zones configured for the account, starting with
+// the default zone.
+func (a *backendAccount) zones() []string {
+	zones := []string{a.Zone}
+	for _, zone := range a.Zones {
+		if !strutil.StrListContains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+
+	return zones
+}
+
+// exoscaleAccount represents an Exoscale account instances are looked up in
+// during authentication.
+type exoscaleAccount struct {
+	// name is the name of the account, empty for the account of the backend
+	// root configuration.
+	name           string
+	exo            exoscaleClient
+	apiEnvironment string
+	zones          []string
+}
+
+func pathListAccounts(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/account/?$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{Callback: b.listAccounts},
+		},
+
+		HelpSynopsis:    pathListAccountsHelpSyn,
+		HelpDescription: pathListAccountsHelpDesc,
+	}
+}
+
+func pathAccount(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/account/" + framework.GenericNameRegex(accountKeyName),
+		Fields: map[string]*framework.FieldSchema{
+			accountKeyName: {
+				Type:        framework.TypeString,
+				Description: "Name of the account",
+				Required:    true,
+			},
+			configKeyAPIEnvironment: {
+				Type:        framework.TypeString,
+				Description: "Exoscale API environment",
+				Default:     defaultAPIEnvironment,
+			},
+			configKeyAPIKey: {
+				Type:         framework.TypeString,
+				Description:  "Exoscale API key",
+				Required:     true,
+				DisplayAttrs: &framework.DisplayAttributes{Sensitive: true},
+			},
+			configKeyAPISecret: {
+				Type:         framework.TypeString,
+				Description:  "Exoscale API secret",
+				Required:     true,
+				DisplayAttrs: &framework.DisplayAttributes{Sensitive: true},
+			},
+			configKeyZone: {
+				Type:        framework.TypeString,
+				Description: "Exoscale zone (default zone if several zones are configured)",
+			},
+			configKeyZones: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of Exoscale zones to look up instances in",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{Callback: b.writeAccount},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.writeAccount},
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.readAccount},
+			logical.DeleteOperation: &framework.PathOperation{Callback: b.deleteAccount},
+		},
+
+		HelpSynopsis:    pathAccountHelpSyn,
+		HelpDescription: pathAccountHelpDesc,
+	}
+}
+
+func (b *exoscaleBackend) accountConfig(
+	ctx context.Context,
+	storage logical.Storage,
+	name string,
+) (*backendAccount, error) {
+	var account backendAccount
+
+	entry, err := storage.Get(ctx, accountStoragePathPrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve account %q: %w", name, err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if err := entry.DecodeJSON(&account); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func (b *exoscaleBackend) listAccounts(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	accounts, err := req.Storage.List(ctx, accountStoragePathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(accounts), nil
+}
+
+func (b *exoscaleBackend) readAccount(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	account, err := b.accountConfig(ctx, req.Storage, data.Get(accountKeyName).(string))
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		configKeyAPIEnvironment: account.APIEnvironment,
+		configKeyAPIKey:         account.APIKey,
+		configKeyAPISecret:      account.APISecret,
+		configKeyZone:           account.Zone,
+		configKeyZones:          account.zones(),
+	}}, nil
+}
+
+func (b *exoscaleBackend) writeAccount(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	name := data.Get(accountKeyName).(string)
+
+	account := backendAccount{
+		APIEnvironment: data.Get(configKeyAPIEnvironment).(string),
+		APIKey:         data.Get(configKeyAPIKey).(string),
+		APISecret:      data.Get(configKeyAPISecret).(string),
+		Zone:           data.Get(configKeyZone).(string),
+		Zones:          data.Get(configKeyZones).([]string),
+	}
+
+	if account.Zone == "" {
+		if len(account.Zones) == 0 {
+			return logical.ErrorResponse("%v: %s or %s", errMissingField, configKeyZone, configKeyZones), nil
+		}
+		account.Zone = account.Zones[0]
+	}
+
+	entry, err := logical.StorageEntryJSON(accountStoragePathPrefix+name, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.resetAccountClient(name)
+
+	res := &logical.Response{}
+	res.AddWarning("Read access to this endpoint should be controlled via ACLs as " +
+		"it will return sensitive information as-is, including the account API credentials")
+
+	return res, nil
+}
+
+func (b *exoscaleBackend) deleteAccount(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	name := data.Get(accountKeyName).(string)
+
+	if err := req.Storage.Delete(ctx, accountStoragePathPrefix+name); err != nil {
+		return nil, err
+	}
+
+	b.resetAccountClient(name)
+
+	return nil, nil
+}
+
+// accounts returns the Exoscale accounts instances authenticating using the
+// role are looked up in. The Exoscale API clients of the accounts are
+// initialized on first use, and kept in a registry until the account
+// configuration changes.
+func (b *exoscaleBackend) accounts(
+	ctx context.Context,
+	storage logical.Storage,
+	config *backendConfig,
+	role *backendRole,
+) ([]*exoscaleAccount, error) {
+	if len(role.Accounts) == 0 {
+		return []*exoscaleAccount{{
+			exo:            b.exo,
+			apiEnvironment: config.APIEnvironment,
+			zones:          config.zones(),
+		}}, nil
+	}
+
+	b.accountClientsLock.Lock()
+	defer b.accountClientsLock.Unlock()
+
+	accounts := make([]*exoscaleAccount, 0, len(role.Accounts))
+	for _, name := range role.Accounts {
+		account, err := b.accountConfig(ctx, storage, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+		}
+		if account == nil {
+			return nil, fmt.Errorf("%w: account %q not found", errInternalError, name)
+		}
+
+		exo, ok := b.accountClients[name]
+		if !ok {
+			if exo, err = egoscale.NewClient(account.APIKey, account.APISecret); err != nil {
+				return nil, fmt.Errorf("%w: unable to initialize Exoscale client for account %q: %v", // nolint:errorlint
+					errInternalError,
+					name,
+					err)
+			}
+
+			if b.accountClients == nil {
+				b.accountClients = make(map[string]exoscaleClient)
+			}
+			b.accountClients[name] = exo
+		}
+
+		accounts = append(accounts, &exoscaleAccount{
+			name:           name,
+			exo:            exo,
+			apiEnvironment: account.APIEnvironment,
+			zones:          account.zones(),
+		})
+	}
+
+	return accounts, nil
+}
+
+// resetAccountClient removes the Exoscale API client of the account from the
+// registry, so that it gets initialized again using the current account
+// configuration on next use.
+func (b *exoscaleBackend) resetAccountClient(name string) {
+	b.accountClientsLock.Lock()
+	defer b.accountClientsLock.Unlock()
+
+	delete(b.accountClients, name)
+}
+
+// invalidate is called by Vault when a storage key is modified by another
+// node of the cluster.
+func (b *exoscaleBackend) invalidate(_ context.Context, key string) {
+	switch {
+	case strings.HasPrefix(key, accountStoragePathPrefix):
+		b.resetAccountClient(strings.TrimPrefix(key, accountStoragePathPrefix))
+
+	case strings.HasPrefix(key, roleStoragePathPrefix):
+		b.forgetRoleCELProgram(strings.TrimPrefix(key, roleStoragePathPrefix))
+	}
+}
@@ -0,0 +1,131 @@
+package exoscale
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/mock"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+var testAccountName = "prod"
+
+func (ts *backendTestSuite) TestPathAccountWrite() {
+	_, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.CreateOperation,
+		Path:      accountStoragePathPrefix + testAccountName,
+		Data: map[string]interface{}{
+			configKeyAPIEnvironment: testConfigAPIEnvironment,
+			configKeyAPIKey:         testConfigAPIKey,
+			configKeyAPISecret:      testConfigAPISecret,
+			configKeyZones:          testConfigZones,
+		},
+	})
+	ts.Require().NoError(err)
+
+	account, err := ts.backend.(*exoscaleBackend).accountConfig(context.Background(), ts.storage, testAccountName)
+	ts.Require().NoError(err)
+	ts.Require().Equal(&backendAccount{
+		APIEnvironment: testConfigAPIEnvironment,
+		APIKey:         testConfigAPIKey,
+		APISecret:      testConfigAPISecret,
+		Zone:           testZone,
+		Zones:          testConfigZones,
+	}, account)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.CreateOperation,
+		Path:      accountStoragePathPrefix + testAccountName,
+		Data: map[string]interface{}{
+			configKeyAPIKey:    testConfigAPIKey,
+			configKeyAPISecret: testConfigAPISecret,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(res.IsError())
+}
+
+func (ts *backendTestSuite) TestPathAccountListDelete() {
+	ts.storeEntry(accountStoragePathPrefix+testAccountName, backendAccount{Zone: testZone})
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ListOperation,
+		Path:      accountStoragePathPrefix,
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{testAccountName}, res.Data["keys"])
+
+	_, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.DeleteOperation,
+		Path:      accountStoragePathPrefix + testAccountName,
+	})
+	ts.Require().NoError(err)
+
+	account, err := ts.backend.(*exoscaleBackend).accountConfig(context.Background(), ts.storage, testAccountName)
+	ts.Require().NoError(err)
+	ts.Require().Nil(account)
+}
+
+func (ts *backendTestSuite) TestPathLoginAccount() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(accountStoragePathPrefix+testAccountName, backendAccount{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator: defaultRoleValidator,
+		Accounts:  []string{testAccountName},
+	})
+
+	accountClient := new(exoscaleClientMock)
+	ts.backend.(*exoscaleBackend).accountClients = map[string]exoscaleClient{testAccountName: accountClient}
+
+	accountClient.
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+	ts.Require().Equal(testAccountName, res.Auth.InternalData["account"])
+
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.RenewOperation,
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Auth:       &logical.Auth{InternalData: res.Auth.InternalData},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	accountClient.AssertNumberOfCalls(ts.T(), "GetInstance", 2)
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertNotCalled(ts.T(), "GetInstance")
+
+	// Roles can't be bound to unknown accounts.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName,
+		Data:      map[string]interface{}{roleKeyAccounts: "staging"},
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(res.IsError())
+}
@@ -11,6 +11,8 @@ var (
 	testConfigAPIEnvironment = "test"
 	testConfigAPIKey         = "EXOabcdef0123456789abcdef01"
 	testConfigAPISecret      = "ABCDEFGHIJKLMNOPRQSTUVWXYZ0123456789abcdefg"
+	testConfigTrustedProxies = []string{"10.0.0.0/24"}
+	testConfigZones          = []string{testZone, "de-fra-1"}
 )
 
 func (ts *backendTestSuite) TestPathConfigWrite() {
@@ -23,7 +25,8 @@ func (ts *backendTestSuite) TestPathConfigWrite() {
 			configKeyAPIKey:         testConfigAPIKey,
 			configKeyAPISecret:      testConfigAPISecret,
 			configKeyAppRoleMode:    true,
-			configKeyZone:           testZone,
+			configKeyTrustedProxies: testConfigTrustedProxies,
+			configKeyZones:          testConfigZones,
 		},
 	})
 
@@ -33,12 +36,20 @@ func (ts *backendTestSuite) TestPathConfigWrite() {
 	ts.Require().NoError(err)
 	ts.Require().NoError(entry.DecodeJSON(&actual))
 	require.Equal(ts.T(), backendConfig{
+		APIConcurrency: defaultAPIConcurrency,
 		APIEnvironment: testConfigAPIEnvironment,
 		APIKey:         testConfigAPIKey,
 		APISecret:      testConfigAPISecret,
 		AppRoleMode:    true,
+		TrustedProxies: testConfigTrustedProxies,
 		Zone:           testZone,
+		Zones:          testConfigZones,
 	}, actual)
+
+	// The login nonces key is generated along with the configuration.
+	entry, err = ts.storage.Get(context.Background(), nonceKeyStoragePath)
+	ts.Require().NoError(err)
+	ts.Require().NotNil(entry)
 }
 
 func (ts *backendTestSuite) TestPathConfigRead() {
@@ -47,7 +58,9 @@ func (ts *backendTestSuite) TestPathConfigRead() {
 		APIKey:         testConfigAPIKey,
 		APISecret:      testConfigAPISecret,
 		AppRoleMode:    true,
+		TrustedProxies: testConfigTrustedProxies,
 		Zone:           testZone,
+		Zones:          testConfigZones,
 	})
 
 	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
@@ -59,9 +72,12 @@ func (ts *backendTestSuite) TestPathConfigRead() {
 		ts.FailNow("request failed", err)
 	}
 
+	require.Equal(ts.T(), defaultAPIConcurrency, res.Data[configKeyAPIConcurrency].(int))
 	require.Equal(ts.T(), testConfigAPIEnvironment, res.Data[configKeyAPIEnvironment].(string))
 	require.Equal(ts.T(), testConfigAPIKey, res.Data[configKeyAPIKey].(string))
 	require.Equal(ts.T(), testConfigAPISecret, res.Data[configKeyAPISecret].(string))
 	require.True(ts.T(), res.Data[configKeyAppRoleMode].(bool))
+	require.Equal(ts.T(), testConfigTrustedProxies, res.Data[configKeyTrustedProxies].([]string))
 	require.Equal(ts.T(), testZone, res.Data[configKeyZone].(string))
+	require.Equal(ts.T(), testConfigZones, res.Data[configKeyZones].([]string))
 }
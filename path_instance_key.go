@@ -0,0 +1,176 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	instanceKeyStoragePathPrefix = "instance-key/"
+
+	instanceKeyKeyCreated     = "created"
+	instanceKeyKeyFingerprint = "fingerprint"
+	instanceKeyKeyInstance    = "instance"
+	instanceKeyKeyPublicKey   = "public_key"
+	instanceKeyKeyRole        = "role"
+	instanceKeyKeyZone        = "zone"
+)
+
+var (
+	pathListInstanceKeysHelpSyn  = "List the instance key bindings"
+	pathListInstanceKeysHelpDesc = `
+This endpoint returns the list of IDs of the Compute instances having a public
+key bound to them (trust-on-first-use).
+`
+
+	pathInstanceKeyHelpSyn  = "Manage instance key bindings"
+	pathInstanceKeyHelpDesc = `
+This endpoint manages the public keys bound to Compute instances authenticating
+using roles with trust-on-first-use key binding enabled. Deleting an instance
+key binding resets it: the next successful login of the instance will bind the
+public key it submits.
+`
+)
+
+type instanceKey struct {
+	PublicKey []byte    `json:"public_key"`
+	Role      string    `json:"role"`
+	Zone      string    `json:"zone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Fingerprint returns the hex-encoded SHA-256 digest of the instance public key.
+func (k *instanceKey) Fingerprint() string {
+	sum := sha256.Sum256(k.PublicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that signature is a valid signature of message made using the
+// instance private key.
+func (k *instanceKey) Verify(message, signature []byte) bool {
+	if len(k.PublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(k.PublicKey, message, signature)
+}
+
+func pathListInstanceKeys(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "instance-key/?$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{Callback: b.listInstanceKeys},
+		},
+
+		HelpSynopsis:    pathListInstanceKeysHelpSyn,
+		HelpDescription: pathListInstanceKeysHelpDesc,
+	}
+}
+
+func pathInstanceKey(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "instance-key/" + framework.GenericNameRegex(instanceKeyKeyInstance),
+		Fields: map[string]*framework.FieldSchema{
+			instanceKeyKeyInstance: {
+				Type:        framework.TypeString,
+				Description: "Instance ID",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.readInstanceKey},
+			logical.DeleteOperation: &framework.PathOperation{Callback: b.deleteInstanceKey},
+		},
+
+		HelpSynopsis:    pathInstanceKeyHelpSyn,
+		HelpDescription: pathInstanceKeyHelpDesc,
+	}
+}
+
+func (b *exoscaleBackend) instanceKey(
+	ctx context.Context,
+	storage logical.Storage,
+	instanceID string,
+) (*instanceKey, error) {
+	var key instanceKey
+
+	entry, err := storage.Get(ctx, instanceKeyStoragePathPrefix+instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve instance %q key: %w", instanceID, err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if err := entry.DecodeJSON(&key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (b *exoscaleBackend) listInstanceKeys(
+	ctx context.Context,
+	req *logical.Request,
+	_ *framework.FieldData,
+) (*logical.Response, error) {
+	instances, err := req.Storage.List(ctx, instanceKeyStoragePathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(instances), nil
+}
+
+func (b *exoscaleBackend) readInstanceKey(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	instanceID := data.Get(instanceKeyKeyInstance).(string)
+
+	key, err := b.instanceKey(ctx, req.Storage, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		instanceKeyKeyCreated:     key.CreatedAt.Format(time.RFC3339),
+		instanceKeyKeyFingerprint: key.Fingerprint(),
+		instanceKeyKeyPublicKey:   base64.StdEncoding.EncodeToString(key.PublicKey),
+		instanceKeyKeyRole:        key.Role,
+		instanceKeyKeyZone:        key.Zone,
+	}}, nil
+}
+
+func (b *exoscaleBackend) deleteInstanceKey(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	instanceID := data.Get(instanceKeyKeyInstance).(string)
+
+	b.instanceKeyLock.Lock()
+	defer b.instanceKeyLock.Unlock()
+
+	if err := req.Storage.Delete(ctx, instanceKeyStoragePathPrefix+instanceID); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("instance key binding reset", "instance_id", instanceID)
+
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var testInstanceKeyPublicKey = func() ed25519.PublicKey {
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	return publicKey
+}()
+
+func (ts *backendTestSuite) TestPathInstanceKeyRead() {
+	key := instanceKey{
+		PublicKey: testInstanceKeyPublicKey,
+		Role:      testRoleName,
+		Zone:      testZone,
+		CreatedAt: time.Now().UTC(),
+	}
+	ts.storeEntry(instanceKeyStoragePathPrefix+testInstanceID, key)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ReadOperation,
+		Path:      instanceKeyStoragePathPrefix + testInstanceID,
+	})
+	if err != nil {
+		ts.FailNow("request failed", err)
+	}
+
+	ts.Require().Equal(key.Fingerprint(), res.Data[instanceKeyKeyFingerprint].(string))
+	ts.Require().Equal(
+		base64.StdEncoding.EncodeToString(testInstanceKeyPublicKey),
+		res.Data[instanceKeyKeyPublicKey].(string),
+	)
+	ts.Require().Equal(testRoleName, res.Data[instanceKeyKeyRole].(string))
+	ts.Require().Equal(testZone, res.Data[instanceKeyKeyZone].(string))
+}
+
+func (ts *backendTestSuite) TestPathInstanceKeyList() {
+	ts.storeEntry(instanceKeyStoragePathPrefix+testInstanceID, instanceKey{PublicKey: testInstanceKeyPublicKey})
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ListOperation,
+		Path:      instanceKeyStoragePathPrefix,
+	})
+	if err != nil {
+		ts.FailNow("request failed", err)
+	}
+
+	ts.Require().Equal(logical.ListResponse([]string{testInstanceID}), res)
+}
+
+func (ts *backendTestSuite) TestPathInstanceKeyDelete() {
+	ts.storeEntry(instanceKeyStoragePathPrefix+testInstanceID, instanceKey{PublicKey: testInstanceKeyPublicKey})
+
+	_, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.DeleteOperation,
+		Path:      instanceKeyStoragePathPrefix + testInstanceID,
+	})
+	if err != nil {
+		ts.FailNow("request failed", err)
+	}
+
+	res, err := ts.storage.Get(context.Background(), instanceKeyStoragePathPrefix+testInstanceID)
+	ts.Require().NoError(err)
+	ts.Require().Nil(res)
+}
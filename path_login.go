@@ -10,10 +10,17 @@ import (
 )
 
 const (
-	authLoginParamInstance = "instance"
-	authLoginParamRole     = "role"
-	authLoginParamRoleID   = "role_id"
-	authLoginParamSecretID = "secret_id"
+	authLoginParamBootstrapSecret     = "bootstrap_secret"
+	authLoginParamInstance            = "instance"
+	authLoginParamInstanceName        = "instance_name"
+	authLoginParamNonce               = "nonce"
+	authLoginParamPublicKey           = "public_key"
+	authLoginParamRole                = "role"
+	authLoginParamRoleID              = "role_id"
+	authLoginParamSecretID            = "secret_id"
+	authLoginParamServiceAccountToken = "service_account_token"
+	authLoginParamSignature           = "signature"
+	authLoginParamZone                = "zone"
 )
 
 var (
@@ -22,28 +29,70 @@ var (
 This endpoint authenticates using the properties of an Exoscale Compute
 Instance. When authenticating, the Vault auth backend verifies the Instance
 ID provided by the client, and grants a Vault token if they match actual
-resources.
+resources. Alternatively, the client can provide the name of the Instance
+instead of its ID: login is refused if several Instances bear this name. If
+the client specifies the Instance zone, the Instance is only looked up in
+this zone, otherwise all the zones configured in the backend are probed. If neither an Instance ID nor name is provided, the
+backend looks up the Instance whose public, Elastic or Private Network IP
+address matches the client IP address; login is refused if no or several
+Instances match.
 
 By default, the exoscale auth method only checks that the Compute instance
 corresponding to the ID specified by the client actually exists; depending on
 the specified role, additional checks can be performed to further authenticate
-clients (see role-related path for more information).
+clients (see role-related path for more information). If the role requires
+it, the client must also provide the instance bootstrap secret.
+
+If the role has trust-on-first-use key binding enabled, the client must also
+provide a nonce previously obtained from the "nonce" endpoint along with its
+Ed25519 signature (base64-encoded) made with the instance private key. Upon
+the first successful login of an instance, the public key (base64-encoded)
+submitted by the client is bound to the instance: subsequent logins must be
+signed using the corresponding private key.
+
+If the role is bound to an SKS cluster, the client must also provide a
+Kubernetes service account token issued by this cluster, and the instance
+must be a node of this cluster.
+
+If the role requires secret IDs, the client must also provide in the
+"secret_id" parameter a secret ID generated for the role (see the
+"role/<name>/secret-id" endpoint), in addition to the instance ID (which must
+then be passed in the "instance" parameter even in AppRole-compatible mode).
 `
 
-	errAuthFailed        = errors.New("authentication failed")
-	errInternalError     = errors.New("internal error")
-	errInvalidFieldValue = errors.New("invalid field value")
-	errMissingField      = errors.New("missing field")
+	errAuthFailed         = errors.New("authentication failed")
+	errInstanceNotFound   = fmt.Errorf("%w: instance not found", errAuthFailed)
+	errInstanceNotRunning = errors.New("instance is not running")
+	errInternalError      = errors.New("internal error")
+	errInvalidFieldValue  = errors.New("invalid field value")
+	errMissingField       = errors.New("missing field")
 )
 
 func pathLogin(b *exoscaleBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "login",
 		Fields: map[string]*framework.FieldSchema{
+			authLoginParamBootstrapSecret: {
+				Type:         framework.TypeString,
+				Description:  "Instance bootstrap secret",
+				DisplayAttrs: &framework.DisplayAttributes{Sensitive: true},
+			},
 			authLoginParamInstance: {
 				Type:        framework.TypeString,
 				Description: "Instance ID",
 			},
+			authLoginParamInstanceName: {
+				Type:        framework.TypeString,
+				Description: "Instance name (if the instance ID is not supplied)",
+			},
+			authLoginParamNonce: {
+				Type:        framework.TypeString,
+				Description: "Login nonce issued by the backend",
+			},
+			authLoginParamPublicKey: {
+				Type:        framework.TypeString,
+				Description: "Instance Ed25519 public key (base64-encoded)",
+			},
 			authLoginParamRole: {
 				Type:        framework.TypeString,
 				Description: "Role name",
@@ -56,10 +105,18 @@ func pathLogin(b *exoscaleBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "AppRole SecretID (instance ID)",
 			},
-			"zone": {
+			authLoginParamServiceAccountToken: {
+				Type:         framework.TypeString,
+				Description:  "Kubernetes service account token (SKS roles only)",
+				DisplayAttrs: &framework.DisplayAttributes{Sensitive: true},
+			},
+			authLoginParamSignature: {
 				Type:        framework.TypeString,
-				Description: "Instance zone",
-				Deprecated:  true,
+				Description: "Ed25519 signature of the login nonce (base64-encoded)",
+			},
+			authLoginParamZone: {
+				Type:        framework.TypeString,
+				Description: "Instance zone (default: look up the instance in all configured zones)",
 			},
 		},
 
@@ -87,12 +144,10 @@ func (b *exoscaleBackend) pathLoginWrite(
 	}
 
 	roleParamName := authLoginParamRole
-	instanceParamName := authLoginParamInstance
-	// In AppRole-compatible mode, we expect `role`/`instance` parameters to be passed using
-	// the same name as in the AppRole authentication method (`role_id`/`secret_id`).
+	// In AppRole-compatible mode, we expect the `role` parameter to be passed using
+	// the same name as in the AppRole authentication method (`role_id`).
 	if config.AppRoleMode {
 		roleParamName = authLoginParamRoleID
-		instanceParamName = authLoginParamSecretID
 	}
 
 	if _, ok := data.GetOk(roleParamName); !ok {
@@ -112,13 +167,25 @@ func (b *exoscaleBackend) pathLoginWrite(
 		return logical.ErrorResponse("role %q not found", roleName), nil
 	}
 
-	if _, ok := data.GetOk(instanceParamName); !ok {
-		return logical.ErrorResponse("%v: %s", errMissingField, instanceParamName), nil
+	var keyFingerprint string
+	account, instance, serviceAccount, err := b.auth(ctx, role, req, data)
+	if err == nil && role.KeyBinding {
+		keyFingerprint, err = b.bindInstanceKey(ctx, req, data, roleName, instance)
+	}
+	// The secret ID is consumed last, so that failed login attempts don't
+	// exhaust its number of uses.
+	if err == nil && role.BindSecretID {
+		err = b.checkRoleSecretID(
+			ctx,
+			req.Storage,
+			roleName,
+			data.Get(authLoginParamSecretID).(string),
+			instance,
+			true,
+		)
 	}
-
-	instance, err := b.auth(ctx, role, req, data)
 	if err != nil {
-		b.Logger().Error(err.Error(), "client_remote_addr", req.Connection.RemoteAddr)
+		b.Logger().Error(err.Error(), authErrorLogArgs(req, roleName, err)...)
 
 		switch {
 		case errors.Is(err, errMissingField), errors.Is(err, errInvalidFieldValue):
@@ -137,6 +204,7 @@ func (b *exoscaleBackend) pathLoginWrite(
 		"instance_id", *instance.ID,
 		"instance_name", instance.Name,
 		"zone", instance.Zone,
+		"account", account.name,
 	)
 
 	auth := &logical.Auth{
@@ -147,9 +215,46 @@ func (b *exoscaleBackend) pathLoginWrite(
 		},
 	}
 
+	if account.name != "" {
+		auth.InternalData["account"] = account.name
+	}
+
+	// The client IP address forwarded by a trusted proxy is recorded, as
+	// Vault doesn't pass the forwarding headers through upon token renewal.
+	if clientIP, err := config.clientIP(req); err == nil && clientIP != canonicalIP(req.Connection.RemoteAddr) {
+		auth.InternalData["client_ip"] = clientIP
+	}
+
+	if role.KeyBinding {
+		auth.InternalData["key_fingerprint"] = keyFingerprint
+	}
+
+	if serviceAccount != nil {
+		auth.InternalData["sa_namespace"] = serviceAccount.Namespace
+		auth.InternalData["sa_name"] = serviceAccount.Name
+	}
+
 	role.PopulateTokenAuth(auth)
 
 	return &logical.Response{
 		Auth: auth,
 	}, nil
 }
+
+// authErrorLogArgs returns the structured logging arguments of an instance
+// authentication error. Denials of the role validator are only disclosed to
+// operators through the backend log, as clients are merely told that
+// permission is denied.
+func authErrorLogArgs(req *logical.Request, roleName string, err error) []interface{} {
+	args := []interface{}{
+		"client_remote_addr", req.Connection.RemoteAddr,
+		"role", roleName,
+	}
+
+	var validationErr *roleValidationError
+	if errors.As(err, &validationErr) {
+		args = append(args, "validator_denials", validationErr.denials)
+	}
+
+	return args
+}
@@ -0,0 +1,364 @@
+package exoscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+const (
+	authLoginParamIAMRequestAuthorization = "iam_request_authorization"
+	authLoginParamIAMRequestPath          = "iam_request_path"
+
+	// iamLoginAudienceQueryParam is the name of the request query parameter,
+	// set to the backend IAM audience, that must be signed by the client.
+	iamLoginAudienceQueryParam = "vault-audience"
+
+	// iamLoginMaxRequestExpiration is the maximum validity of the pre-signed
+	// requests accepted by the backend.
+	iamLoginMaxRequestExpiration = 15 * time.Minute
+
+	iamLoginRequestTimeout = 10 * time.Second
+)
+
+var (
+	pathLoginIAMHelpSyn  = "Log in via an Exoscale IAM access key"
+	pathLoginIAMHelpDesc = `
+This endpoint authenticates clients holding an Exoscale IAM access key, such
+as workloads not running on Exoscale Compute instances. The client pre-signs
+(using its IAM API secret) an Exoscale API request retrieving the details of
+its own IAM access key, which the backend replays against the configured
+Exoscale API environment: if the request succeeds, the resulting IAM access
+key identity (key, name and IAM role ID) is matched against the bindings of
+the specified backend role, which must be of type "iam".
+
+The "iam_request_path" parameter must be the path (including query string
+parameters, if any) of the API request, e.g. "/v2/access-key/EXO...", and the
+"iam_request_authorization" parameter the value of the Authorization header
+of the signed request. The request expiration must not exceed %s. The
+request must also include a signed "%s" query string parameter set to the
+"iam_audience" configured on the backend: IAM access key logins are refused
+if the backend has no audience configured.
+
+Upon token renewal, the backend retrieves the IAM access key details using its
+own API credentials: renewal is refused if the access key no longer exists or
+no longer matches the role bindings.
+`
+
+	iamLoginRequestPathRegexp = regexp.MustCompile(`^/v2/access-key/([^/?]+)$`)
+)
+
+// iamAccessKey represents the Exoscale IAM access key identity returned by the
+// Exoscale API upon replay of a pre-signed login request.
+type iamAccessKey struct {
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	RoleID string `json:"role-id"`
+}
+
+func pathLoginIAM(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login/iam",
+		Fields: map[string]*framework.FieldSchema{
+			authLoginParamIAMRequestAuthorization: {
+				Type:         framework.TypeString,
+				Description:  "Authorization header value of the pre-signed Exoscale API request",
+				DisplayAttrs: &framework.DisplayAttributes{Sensitive: true},
+			},
+			authLoginParamIAMRequestPath: {
+				Type:        framework.TypeString,
+				Description: "Path of the pre-signed Exoscale API request",
+			},
+			authLoginParamRole: {
+				Type:        framework.TypeString,
+				Description: "Role name",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathLoginIAMWrite},
+		},
+
+		HelpSynopsis: pathLoginIAMHelpSyn,
+		HelpDescription: fmt.Sprintf(pathLoginIAMHelpDesc,
+			iamLoginMaxRequestExpiration,
+			iamLoginAudienceQueryParam),
+	}
+}
+
+func (b *exoscaleBackend) pathLoginIAMWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil || config == nil {
+		return nil, errors.New("backend is not configured")
+	}
+
+	for _, param := range []string{
+		authLoginParamRole,
+		authLoginParamIAMRequestPath,
+		authLoginParamIAMRequestAuthorization,
+	} {
+		if v, ok := data.GetOk(param); !ok || v.(string) == "" {
+			return logical.ErrorResponse("%v: %s", errMissingField, param), nil
+		}
+	}
+	roleName := data.Get(authLoginParamRole).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		b.Logger().Error(
+			fmt.Sprintf("unable to retrieve role %q: %s", roleName, err),
+			"client_remote_addr", req.Connection.RemoteAddr,
+		)
+		return nil, errInternalError
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	// Without an audience, pre-signed requests intended for other parties
+	// (e.g. another Vault server) could be replayed against this backend.
+	if config.IAMAudience == "" {
+		return logical.ErrorResponse("%q must be configured to allow IAM access key logins",
+			configKeyIAMAudience), nil
+	}
+
+	accessKey, err := b.authIAM(
+		ctx,
+		config,
+		role,
+		data.Get(authLoginParamIAMRequestPath).(string),
+		data.Get(authLoginParamIAMRequestAuthorization).(string),
+	)
+	if err != nil {
+		b.Logger().Error(err.Error(), "client_remote_addr", req.Connection.RemoteAddr)
+
+		switch {
+		case errors.Is(err, errMissingField), errors.Is(err, errInvalidFieldValue):
+			return logical.ErrorResponse(err.Error()), nil
+
+		case errors.Is(err, errAuthFailed):
+			return nil, logical.ErrPermissionDenied
+
+		default:
+			return nil, err
+		}
+	}
+
+	b.Logger().Debug(
+		"successfully authenticated IAM access key",
+		"iam_access_key", accessKey.Key,
+		"iam_access_key_name", accessKey.Name,
+	)
+
+	auth := &logical.Auth{
+		InternalData: map[string]interface{}{
+			"iam_access_key":      accessKey.Key,
+			"iam_access_key_name": accessKey.Name,
+			"iam_role_id":         accessKey.RoleID,
+			"role":                roleName,
+		},
+	}
+
+	role.PopulateTokenAuth(auth)
+
+	return &logical.Response{
+		Auth: auth,
+	}, nil
+}
+
+// authIAM replays the Exoscale API request pre-signed by the client against
+// the configured Exoscale API environment, and checks the resulting IAM
+// access key identity against the role bindings.
+func (b *exoscaleBackend) authIAM(
+	ctx context.Context,
+	config *backendConfig,
+	role *backendRole,
+	reqPath string,
+	authorization string,
+) (*iamAccessKey, error) {
+	if role.AuthType != roleAuthTypeIAM {
+		return nil, fmt.Errorf("%w: role doesn't support IAM access key authentication", errAuthFailed)
+	}
+
+	reqURL, err := url.Parse(reqPath)
+	if err != nil || reqURL.Scheme != "" || reqURL.Host != "" {
+		return nil, fmt.Errorf("%w: %s", errInvalidFieldValue, authLoginParamIAMRequestPath)
+	}
+
+	m := iamLoginRequestPathRegexp.FindStringSubmatch(reqURL.Path)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %s: unsupported request path", errInvalidFieldValue, authLoginParamIAMRequestPath)
+	}
+	requestedKey := m[1]
+
+	signature, err := parseIAMRequestAuthorization(authorization)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidFieldValue, authLoginParamIAMRequestAuthorization, err) // nolint:errorlint
+	}
+
+	// The request must be about the signing access key itself, otherwise any
+	// key allowed to read other keys details could impersonate them.
+	if signature.credential != requestedKey {
+		return nil, fmt.Errorf("%w: request signing key doesn't match the requested access key", errAuthFailed)
+	}
+
+	if signature.expires.After(time.Now().Add(iamLoginMaxRequestExpiration)) {
+		return nil, fmt.Errorf("%w: request expiration exceeds %s", errAuthFailed, iamLoginMaxRequestExpiration)
+	}
+
+	if reqURL.Query().Get(iamLoginAudienceQueryParam) != config.IAMAudience ||
+		!signature.signsQueryParam(iamLoginAudienceQueryParam) {
+		return nil, fmt.Errorf("%w: request audience mismatch", errAuthFailed)
+	}
+
+	accessKey, status, err := b.getIAMAccessKey(ctx, config, reqURL.String(), func(req *http.Request) error {
+		req.Header.Set("Authorization", authorization)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: IAM request rejected by the Exoscale API (%d %s)",
+			errAuthFailed,
+			status,
+			http.StatusText(status))
+
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("%w: unexpected IAM request response status %d %s",
+			errInternalError,
+			status,
+			http.StatusText(status))
+	}
+
+	if accessKey.Key != signature.credential {
+		return nil, fmt.Errorf("%w: IAM request response doesn't match the signing key", errAuthFailed)
+	}
+
+	if err := role.checkIAMAccessKey(accessKey); err != nil {
+		return nil, err
+	}
+
+	return accessKey, nil
+}
+
+// getIAMAccessKey performs an Exoscale API request retrieving the details of
+// an IAM access key, authorized by the authorize function. The access key is
+// only returned if the request succeeded, along with the response status code.
+func (b *exoscaleBackend) getIAMAccessKey(
+	ctx context.Context,
+	config *backendConfig,
+	reqPath string,
+	authorize func(*http.Request) error,
+) (*iamAccessKey, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, iamLoginRequestTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, config.iamAPIEndpoint()+reqPath, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: unable to build IAM request: %v", errInternalError, err) // nolint:errorlint
+	}
+	httpReq.Header.Set("User-Agent", egoscale.UserAgent)
+
+	if err := authorize(httpReq); err != nil {
+		return nil, 0, fmt.Errorf("%w: unable to sign IAM request: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	res, err := b.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: unable to perform IAM request: %v", errInternalError, err) // nolint:errorlint
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, res.StatusCode, nil
+	}
+
+	var accessKey iamAccessKey
+	if err := json.NewDecoder(res.Body).Decode(&accessKey); err != nil {
+		return nil, 0, fmt.Errorf("%w: unable to decode IAM request response: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	return &accessKey, res.StatusCode, nil
+}
+
+type iamRequestSignature struct {
+	credential      string
+	expires         time.Time
+	signedQueryArgs []string
+}
+
+func (s *iamRequestSignature) signsQueryParam(name string) bool {
+	for _, arg := range s.signedQueryArgs {
+		if arg == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIAMRequestAuthorization parses an Exoscale API v2 request
+// Authorization header value, formatted as:
+// EXO2-HMAC-SHA256 credential=<key>,signed-query-args=<a;b>,expires=<ts>,signature=<sig>
+func parseIAMRequestAuthorization(v string) (*iamRequestSignature, error) {
+	const scheme = "EXO2-HMAC-SHA256 "
+
+	if !strings.HasPrefix(v, scheme) {
+		return nil, errors.New("unsupported authorization scheme")
+	}
+
+	var (
+		signature    iamRequestSignature
+		hasSignature bool
+	)
+
+	for _, part := range strings.Split(strings.TrimPrefix(v, scheme), ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid authorization pragma %q", part)
+		}
+
+		switch kv[0] {
+		case "credential":
+			signature.credential = kv[1]
+
+		case "signed-query-args":
+			signature.signedQueryArgs = strings.Split(kv[1], ";")
+
+		case "expires":
+			ts, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiration %q", kv[1])
+			}
+			signature.expires = time.Unix(ts, 0)
+
+		case "signature":
+			hasSignature = kv[1] != ""
+		}
+	}
+
+	if signature.credential == "" || signature.expires.IsZero() || !hasSignature {
+		return nil, errors.New("incomplete authorization")
+	}
+
+	return &signature, nil
+}
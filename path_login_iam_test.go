@@ -0,0 +1,264 @@
+package exoscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var (
+	testIAMAccessKey     = "EXO" + new(backendTestSuite).randomString(24)
+	testIAMAccessKeyName = new(backendTestSuite).randomString(10)
+	testIAMAudience      = "vault.example.net"
+	testIAMRoleID        = new(backendTestSuite).randomID()
+)
+
+// testIAMAuthorization returns an Exoscale API v2 request Authorization header
+// value for the specified key, the signature itself being verified by the
+// stand-in API server.
+func testIAMAuthorization(key string, expires time.Time, signedQueryArgs ...string) string {
+	parts := []string{"EXO2-HMAC-SHA256 credential=" + key}
+	if len(signedQueryArgs) > 0 {
+		parts = append(parts, "signed-query-args="+strings.Join(signedQueryArgs, ";"))
+	}
+	parts = append(parts,
+		fmt.Sprintf("expires=%d", expires.Unix()),
+		"signature=dGVzdA==")
+
+	return strings.Join(parts, ",")
+}
+
+func (ts *backendTestSuite) TestPathLoginIAM() {
+	// Stand-in Exoscale API endpoint, accepting only requests signed for the
+	// test IAM access key.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "credential="+testIAMAccessKey+",") ||
+			r.URL.Path != "/v2/access-key/"+testIAMAccessKey {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"key":     testIAMAccessKey,
+			"name":    testIAMAccessKeyName,
+			"role-id": testIAMRoleID,
+		})
+	}))
+	defer api.Close()
+
+	tests := []struct {
+		name         string
+		role         backendRole
+		resCheckFunc func(*backendTestSuite, *logical.Response, error)
+		reqData      map[string]interface{}
+		wantErr      bool
+	}{
+		{
+			name: "fail_instance_role",
+			role: backendRole{Validator: defaultRoleValidator},
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					testIAMAccessKey, time.Now().Add(time.Minute), iamLoginAudienceQueryParam),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail_key_mismatch",
+			role: backendRole{AuthType: roleAuthTypeIAM, BoundIAMRoleIDs: []string{testIAMRoleID}},
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					"EXOlolnope", time.Now().Add(time.Minute), iamLoginAudienceQueryParam),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail_audience_not_signed",
+			role: backendRole{AuthType: roleAuthTypeIAM, BoundIAMRoleIDs: []string{testIAMRoleID}},
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					testIAMAccessKey, time.Now().Add(time.Minute)),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+			},
+			wantErr: true,
+		},
+		{
+			name: "fail_unbound_key_name",
+			role: backendRole{AuthType: roleAuthTypeIAM, BoundIAMKeyNames: []string{"lolnope"}},
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					testIAMAccessKey, time.Now().Add(time.Minute), iamLoginAudienceQueryParam),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ok",
+			role: backendRole{
+				AuthType:         roleAuthTypeIAM,
+				BoundIAMKeyNames: []string{testIAMAccessKeyName},
+				BoundIAMRoleIDs:  []string{testIAMRoleID},
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().Equal(map[string]interface{}{
+					"iam_access_key":      testIAMAccessKey,
+					"iam_access_key_name": testIAMAccessKeyName,
+					"iam_role_id":         testIAMRoleID,
+					"role":                testRoleName,
+				}, res.Auth.InternalData)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					testIAMAccessKey, time.Now().Add(time.Minute), iamLoginAudienceQueryParam),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+			},
+		},
+	}
+
+	ts.storeEntry(configStoragePath, &backendConfig{
+		IAMAPIEndpoint: api.URL,
+		IAMAudience:    testIAMAudience,
+		Zone:           testZone,
+	})
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			ts.storeEntry(roleStoragePathPrefix+testRoleName, tt.role)
+
+			tt.reqData[authLoginParamRole] = testRoleName
+
+			res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+				Storage:    ts.storage,
+				Operation:  logical.UpdateOperation,
+				Path:       "login/iam",
+				Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+				Data:       tt.reqData,
+			})
+			if err != nil != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			tt.resCheckFunc(ts, res, err)
+		})
+	}
+}
+
+func (ts *backendTestSuite) TestPathLoginIAMRenew() {
+	var (
+		testBackendAPIKey = "EXO" + ts.randomString(24)
+		keyDeleted        bool
+	)
+
+	// Stand-in Exoscale API endpoint, accepting requests signed for the test
+	// IAM access key or the backend API key.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.Contains(authorization, "credential="+testIAMAccessKey+",") &&
+			!strings.Contains(authorization, "credential="+testBackendAPIKey+",") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if keyDeleted || r.URL.Path != "/v2/access-key/"+testIAMAccessKey {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"key":     testIAMAccessKey,
+			"name":    testIAMAccessKeyName,
+			"role-id": testIAMRoleID,
+		})
+	}))
+	defer api.Close()
+
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		AuthType:        roleAuthTypeIAM,
+		BoundIAMRoleIDs: []string{testIAMRoleID},
+	})
+
+	login := func() (*logical.Response, error) {
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login/iam",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data: map[string]interface{}{
+				authLoginParamIAMRequestAuthorization: testIAMAuthorization(
+					testIAMAccessKey, time.Now().Add(time.Minute), iamLoginAudienceQueryParam),
+				authLoginParamIAMRequestPath: "/v2/access-key/" + testIAMAccessKey +
+					"?" + iamLoginAudienceQueryParam + "=" + testIAMAudience,
+				authLoginParamRole: testRoleName,
+			},
+		})
+	}
+
+	renew := func(auth *logical.Auth) (*logical.Response, error) {
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.RenewOperation,
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Auth:       &logical.Auth{InternalData: auth.InternalData},
+		})
+	}
+
+	// No audience configured: IAM logins are refused.
+	ts.storeEntry(configStoragePath, &backendConfig{
+		APIKey:         testBackendAPIKey,
+		APISecret:      ts.randomString(43),
+		IAMAPIEndpoint: api.URL,
+		Zone:           testZone,
+	})
+	res, err := login()
+	ts.Require().NoError(err)
+	ts.Require().True(res.IsError())
+	ts.Require().Contains(res.Error().Error(), configKeyIAMAudience)
+
+	ts.storeEntry(configStoragePath, &backendConfig{
+		APIKey:         testBackendAPIKey,
+		APISecret:      ts.randomString(43),
+		IAMAPIEndpoint: api.URL,
+		IAMAudience:    testIAMAudience,
+		Zone:           testZone,
+	})
+	res, err = login()
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+	auth := res.Auth
+
+	res, err = renew(auth)
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	// The access key has been deleted since login: renewal is refused.
+	keyDeleted = true
+	_, err = renew(auth)
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+}
@@ -2,6 +2,10 @@ package exoscale
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strings"
@@ -16,19 +20,44 @@ import (
 )
 
 var (
-	testInstanceCreated           = time.Now().Add(-time.Minute)
-	testInstanceID                = new(backendTestSuite).randomID()
-	testInstanceIPAddress         = net.ParseIP("1.2.3.4")
-	testInstanceLabels            = map[string]string{"k1": "v1", "k2": "v2"}
-	testInstanceName              = new(backendTestSuite).randomString(10)
-	testInstancePoolID            = new(backendTestSuite).randomID()
-	testInstancePoolName          = new(backendTestSuite).randomString(10)
-	testInstanceSecurityGroupID   = new(backendTestSuite).randomID()
-	testInstanceSecurityGroupName = new(backendTestSuite).randomString(10)
-	testInstanceState             = "running"
-	testInstanceTemplateID        = new(backendTestSuite).randomID()
-	testInstanceTypeID            = new(backendTestSuite).randomID()
-	testZone                      = "ch-gva-2"
+	testInstanceAAGID              = new(backendTestSuite).randomID()
+	testInstanceAAGName            = new(backendTestSuite).randomString(10)
+	testInstanceBootstrapSecret    = "s3cr3t"
+	testInstanceCreated            = time.Now().Add(-time.Minute)
+	testInstanceDeployTargetID     = new(backendTestSuite).randomID()
+	testInstanceDeployTargetName   = new(backendTestSuite).randomString(10)
+	testInstanceElasticIPAddress   = net.ParseIP("5.6.7.8")
+	testInstanceElasticIPID        = new(backendTestSuite).randomID()
+	testInstanceID                 = new(backendTestSuite).randomID()
+	testInstanceIPAddress          = net.ParseIP("1.2.3.4")
+	testInstanceIPv6Address        = net.ParseIP("2001:db8::42")
+	testInstanceLabels             = map[string]string{"k1": "v1", "k2": "v2"}
+	testInstanceName               = new(backendTestSuite).randomString(10)
+	testInstancePoolDescription    = new(backendTestSuite).randomString(10)
+	testInstancePoolID             = new(backendTestSuite).randomID()
+	testInstancePoolLabels         = map[string]string{"tier": "web"}
+	testInstancePoolName           = new(backendTestSuite).randomString(10)
+	testInstancePoolSize           = int64(3)
+	testInstancePrivateIPAddress   = net.ParseIP("10.0.0.42")
+	testInstancePrivateNetworkID   = new(backendTestSuite).randomID()
+	testInstancePrivateNetworkName = new(backendTestSuite).randomString(10)
+	testInstanceSecurityGroupID    = new(backendTestSuite).randomID()
+	testInstanceSecurityGroupName  = new(backendTestSuite).randomString(10)
+	testInstanceSSHKeyFingerprint  = "d5:d6:2e:80:36:82:54:2b:3d:5b:0f:1e:1a:3c:2b:1e"
+	testInstanceSSHKeyName         = new(backendTestSuite).randomString(10)
+	testInstanceState              = "running"
+	testInstanceStateStopped       = "stopped"
+	testInstanceTemplateID         = new(backendTestSuite).randomID()
+	testInstanceTemplateName       = "hardened-" + new(backendTestSuite).randomString(10)
+	testInstanceTemplateVisibility = "private"
+	testInstanceTypeCPUs           = int64(2)
+	testInstanceTypeFamily         = "standard"
+	testInstanceTypeID             = new(backendTestSuite).randomID()
+	testInstanceTypeMemory         = int64(4294967296)
+	testInstanceTypeSize           = "medium"
+	testOtherInstanceID            = new(backendTestSuite).randomID()
+	testOtherInstanceIPAddress     = net.ParseIP("4.3.2.1")
+	testZone                       = "ch-gva-2"
 )
 
 func (ts *backendTestSuite) TestPathLogin() {
@@ -37,6 +66,7 @@ func (ts *backendTestSuite) TestPathLogin() {
 		setupFunc    func(*backendTestSuite)
 		resCheckFunc func(*backendTestSuite, *logical.Response, error)
 		reqData      map[string]interface{}
+		remoteAddr   string
 		wantErr      bool
 	}{
 		{
@@ -75,10 +105,12 @@ func (ts *backendTestSuite) TestPathLogin() {
 				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
 					On("GetInstance", mock.Anything, testZone, testInstanceID).
 					Return(&egoscale.Instance{
-						CreatedAt:      &testInstanceCreated,
-						ID:             &testInstanceID,
-						InstanceTypeID: &testInstanceTypeID,
-						Labels:         &testInstanceLabels,
+						AntiAffinityGroupIDs: &[]string{testInstanceAAGID},
+						CreatedAt:            &testInstanceCreated,
+						DeployTargetID:       &testInstanceDeployTargetID,
+						ID:                   &testInstanceID,
+						InstanceTypeID:       &testInstanceTypeID,
+						Labels:               &testInstanceLabels,
 						Manager: &egoscale.InstanceManager{
 							ID:   testInstancePoolID,
 							Type: "instance-pool",
@@ -86,6 +118,7 @@ func (ts *backendTestSuite) TestPathLogin() {
 						Name:             &testInstanceName,
 						PublicIPAddress:  &testInstanceIPAddress,
 						SecurityGroupIDs: &[]string{testInstanceSecurityGroupID},
+						SSHKey:           &testInstanceSSHKeyName,
 						State:            &testInstanceState,
 						TemplateID:       &testInstanceTemplateID,
 						Zone:             &testZone,
@@ -94,11 +127,14 @@ func (ts *backendTestSuite) TestPathLogin() {
 				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
 					On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
 					Return(&egoscale.InstancePool{
+						Description:    &testInstancePoolDescription,
 						ElasticIPIDs:   nil,
 						ID:             &testInstancePoolID,
 						InstanceIDs:    &[]string{testInstanceID},
 						InstanceTypeID: &testInstanceTypeID,
+						Labels:         &testInstancePoolLabels,
 						Name:           &testInstancePoolName,
+						Size:           &testInstancePoolSize,
 						State:          &testInstanceState,
 						TemplateID:     &testInstanceTemplateID,
 						Zone:           &testZone,
@@ -110,6 +146,45 @@ func (ts *backendTestSuite) TestPathLogin() {
 						ID:   &testInstanceSecurityGroupID,
 						Name: &testInstanceSecurityGroupName,
 					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstanceType", mock.Anything, testZone, testInstanceTypeID).
+					Return(&egoscale.InstanceType{
+						CPUs:   &testInstanceTypeCPUs,
+						Family: &testInstanceTypeFamily,
+						ID:     &testInstanceTypeID,
+						Memory: &testInstanceTypeMemory,
+						Size:   &testInstanceTypeSize,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetTemplate", mock.Anything, testZone, testInstanceTemplateID).
+					Return(&egoscale.Template{
+						ID:         &testInstanceTemplateID,
+						Name:       &testInstanceTemplateName,
+						Visibility: &testInstanceTemplateVisibility,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetAntiAffinityGroup", mock.Anything, testZone, testInstanceAAGID).
+					Return(&egoscale.AntiAffinityGroup{
+						ID:   &testInstanceAAGID,
+						Name: &testInstanceAAGName,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetDeployTarget", mock.Anything, testZone, testInstanceDeployTargetID).
+					Return(&egoscale.DeployTarget{
+						ID:   &testInstanceDeployTargetID,
+						Name: &testInstanceDeployTargetName,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetSSHKey", mock.Anything, testZone, testInstanceSSHKeyName).
+					Return(&egoscale.SSHKey{
+						Fingerprint: &testInstanceSSHKeyFingerprint,
+						Name:        &testInstanceSSHKeyName,
+					}, nil)
 			},
 			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
 				ts.Require().NoError(err)
@@ -135,10 +210,12 @@ func (ts *backendTestSuite) TestPathLogin() {
 				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
 					On("GetInstance", mock.Anything, testZone, testInstanceID).
 					Return(&egoscale.Instance{
-						CreatedAt:      &testInstanceCreated,
-						ID:             &testInstanceID,
-						InstanceTypeID: &testInstanceTypeID,
-						Labels:         &testInstanceLabels,
+						AntiAffinityGroupIDs: &[]string{testInstanceAAGID},
+						CreatedAt:            &testInstanceCreated,
+						DeployTargetID:       &testInstanceDeployTargetID,
+						ID:                   &testInstanceID,
+						InstanceTypeID:       &testInstanceTypeID,
+						Labels:               &testInstanceLabels,
 						Manager: &egoscale.InstanceManager{
 							ID:   testInstancePoolID,
 							Type: "instance-pool",
@@ -146,6 +223,7 @@ func (ts *backendTestSuite) TestPathLogin() {
 						Name:             &testInstanceName,
 						PublicIPAddress:  &testInstanceIPAddress,
 						SecurityGroupIDs: &[]string{testInstanceSecurityGroupID},
+						SSHKey:           &testInstanceSSHKeyName,
 						State:            &testInstanceState,
 						TemplateID:       &testInstanceTemplateID,
 						Zone:             &testZone,
@@ -154,11 +232,14 @@ func (ts *backendTestSuite) TestPathLogin() {
 				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
 					On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
 					Return(&egoscale.InstancePool{
+						Description:    &testInstancePoolDescription,
 						ElasticIPIDs:   nil,
 						ID:             &testInstancePoolID,
 						InstanceIDs:    &[]string{testInstanceID},
 						InstanceTypeID: &testInstanceTypeID,
+						Labels:         &testInstancePoolLabels,
 						Name:           &testInstancePoolName,
+						Size:           &testInstancePoolSize,
 						State:          &testInstanceState,
 						TemplateID:     &testInstanceTemplateID,
 						Zone:           &testZone,
@@ -170,6 +251,45 @@ func (ts *backendTestSuite) TestPathLogin() {
 						ID:   &testInstanceSecurityGroupID,
 						Name: &testInstanceSecurityGroupName,
 					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstanceType", mock.Anything, testZone, testInstanceTypeID).
+					Return(&egoscale.InstanceType{
+						CPUs:   &testInstanceTypeCPUs,
+						Family: &testInstanceTypeFamily,
+						ID:     &testInstanceTypeID,
+						Memory: &testInstanceTypeMemory,
+						Size:   &testInstanceTypeSize,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetTemplate", mock.Anything, testZone, testInstanceTemplateID).
+					Return(&egoscale.Template{
+						ID:         &testInstanceTemplateID,
+						Name:       &testInstanceTemplateName,
+						Visibility: &testInstanceTemplateVisibility,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetAntiAffinityGroup", mock.Anything, testZone, testInstanceAAGID).
+					Return(&egoscale.AntiAffinityGroup{
+						ID:   &testInstanceAAGID,
+						Name: &testInstanceAAGName,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetDeployTarget", mock.Anything, testZone, testInstanceDeployTargetID).
+					Return(&egoscale.DeployTarget{
+						ID:   &testInstanceDeployTargetID,
+						Name: &testInstanceDeployTargetName,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetSSHKey", mock.Anything, testZone, testInstanceSSHKeyName).
+					Return(&egoscale.SSHKey{
+						Fingerprint: &testInstanceSSHKeyFingerprint,
+						Name:        &testInstanceSSHKeyName,
+					}, nil)
 			},
 			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, _ error) {
 				ts.Require().Equal(map[string]interface{}{
@@ -183,22 +303,382 @@ func (ts *backendTestSuite) TestPathLogin() {
 				authLoginParamSecretID: testInstanceID,
 			},
 		},
+		{
+			name: "ok_default_validator",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:      &testInstanceCreated,
+						ID:             &testInstanceID,
+						InstanceTypeID: &testInstanceTypeID,
+						Manager: &egoscale.InstanceManager{
+							ID:   testInstancePoolID,
+							Type: "instance-pool",
+						},
+						Name:             &testInstanceName,
+						PublicIPAddress:  &testInstanceIPAddress,
+						SecurityGroupIDs: &[]string{testInstanceSecurityGroupID},
+						State:            &testInstanceState,
+						TemplateID:       &testInstanceTemplateID,
+						Zone:             &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+				ts.assertDefaultValidatorCalls()
+			},
+			reqData: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			},
+		},
+		{
+			name: "fail_bootstrap_secret_mismatch",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+					Validator:             defaultRoleValidator,
+					BootstrapSecretSource: roleBootstrapSecretSourceLabel,
+					BootstrapSecretLabel:  defaultRoleBootstrapSecretLabel,
+				})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt: &testInstanceCreated,
+						ID:        &testInstanceID,
+						Labels: &map[string]string{
+							defaultRoleBootstrapSecretLabel: testInstanceBootstrapSecretHash(),
+						},
+						Name:            &testInstanceName,
+						PublicIPAddress: &testInstanceIPAddress,
+						State:           &testInstanceState,
+						Zone:            &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamBootstrapSecret: "lolnope",
+				authLoginParamInstance:        testInstanceID,
+				authLoginParamRole:            testRoleName,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ok_bootstrap_secret_user_data",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+					Validator:             defaultRoleValidator,
+					BootstrapSecretSource: roleBootstrapSecretSourceUserData,
+				})
+
+				userData := base64.StdEncoding.EncodeToString([]byte(
+					"#cloud-config\n" + bootstrapSecretUserDataMarker + " " + testInstanceBootstrapSecretHash() + "\n",
+				))
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:       &testInstanceCreated,
+						ID:              &testInstanceID,
+						Name:            &testInstanceName,
+						PublicIPAddress: &testInstanceIPAddress,
+						UserData:        &userData,
+						State:           &testInstanceState,
+						Zone:            &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamBootstrapSecret: testInstanceBootstrapSecret,
+				authLoginParamInstance:        testInstanceID,
+				authLoginParamRole:            testRoleName,
+			},
+		},
+		{
+			name: "ok_private_ip",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+					Validator: privateIPRoleValidator + fmt.Sprintf(
+						` && instance_private_ips[%q] == %q`,
+						testInstancePrivateNetworkName,
+						testInstanceIPAddress.String(),
+					),
+				})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:         &testInstanceCreated,
+						ID:                &testInstanceID,
+						Name:              &testInstanceName,
+						PrivateNetworkIDs: &[]string{testInstancePrivateNetworkID},
+						PublicIPAddress:   &testInstancePrivateIPAddress,
+						State:             &testInstanceState,
+						Zone:              &testZone,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetPrivateNetwork", mock.Anything, testZone, testInstancePrivateNetworkID).
+					Return(&egoscale.PrivateNetwork{
+						ID:   &testInstancePrivateNetworkID,
+						Name: &testInstancePrivateNetworkName,
+						Leases: []*egoscale.PrivateNetworkLease{{
+							InstanceID: &testInstanceID,
+							IPAddress:  &testInstanceIPAddress,
+						}},
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			},
+		},
+		{
+			name: "ok_ipv6",
+			setupFunc: func(ts *backendTestSuite) {
+				ipv6Enabled := true
+
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:       &testInstanceCreated,
+						ID:              &testInstanceID,
+						IPv6Address:     &testInstanceIPv6Address,
+						IPv6Enabled:     &ipv6Enabled,
+						Name:            &testInstanceName,
+						PublicIPAddress: &testInstanceIPAddress,
+						State:           &testInstanceState,
+						Zone:            &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			},
+			// Non-canonical form of testInstanceIPv6Address
+			remoteAddr: "2001:DB8:0:0:0:0:0:42",
+		},
+		{
+			name: "fail_instance_not_running",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:       &testInstanceCreated,
+						ID:              &testInstanceID,
+						Name:            &testInstanceName,
+						PublicIPAddress: &testInstanceIPAddress,
+						State:           &testInstanceStateStopped,
+						Zone:            &testZone,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+				ts.Require().Nil(res)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ok_elastic_ip",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(&egoscale.Instance{
+						CreatedAt:       &testInstanceCreated,
+						ElasticIPIDs:    &[]string{testInstanceElasticIPID},
+						ID:              &testInstanceID,
+						Name:            &testInstanceName,
+						PublicIPAddress: &testInstanceIPAddress,
+						State:           &testInstanceState,
+						Zone:            &testZone,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetElasticIP", mock.Anything, testZone, testInstanceElasticIPID).
+					Return(&egoscale.ElasticIP{
+						ID:        &testInstanceElasticIPID,
+						IPAddress: &testInstanceElasticIPAddress,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+			},
+			reqData: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			},
+			remoteAddr: testInstanceElasticIPAddress.String(),
+		},
+		{
+			name: "ok_instance_discovery",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				instance := &egoscale.Instance{
+					CreatedAt:       &testInstanceCreated,
+					ElasticIPIDs:    &[]string{testInstanceElasticIPID},
+					ID:              &testInstanceID,
+					Name:            &testInstanceName,
+					PublicIPAddress: &testInstanceIPAddress,
+					State:           &testInstanceState,
+					Zone:            &testZone,
+				}
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListInstances", mock.Anything, testZone).
+					Return([]*egoscale.Instance{
+						{ID: &testOtherInstanceID, PublicIPAddress: &testOtherInstanceIPAddress},
+						instance,
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListElasticIPs", mock.Anything, testZone).
+					Return([]*egoscale.ElasticIP{{
+						ID:        &testInstanceElasticIPID,
+						IPAddress: &testInstanceElasticIPAddress,
+					}}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListPrivateNetworks", mock.Anything, testZone).
+					Return([]*egoscale.PrivateNetwork{}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetInstance", mock.Anything, testZone, testInstanceID).
+					Return(instance, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetElasticIP", mock.Anything, testZone, testInstanceElasticIPID).
+					Return(&egoscale.ElasticIP{
+						ID:        &testInstanceElasticIPID,
+						IPAddress: &testInstanceElasticIPAddress,
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(res.Auth)
+				ts.Require().Equal(testInstanceID, res.Auth.InternalData["instance_id"])
+			},
+			reqData: map[string]interface{}{
+				authLoginParamRole: testRoleName,
+			},
+			remoteAddr: testInstanceElasticIPAddress.String(),
+		},
+		{
+			name: "fail_instance_discovery_ambiguous",
+			setupFunc: func(ts *backendTestSuite) {
+				ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+				ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListInstances", mock.Anything, testZone).
+					Return([]*egoscale.Instance{
+						{ID: &testInstanceID, PublicIPAddress: &testInstanceIPAddress},
+					}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListElasticIPs", mock.Anything, testZone).
+					Return([]*egoscale.ElasticIP{}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("ListPrivateNetworks", mock.Anything, testZone).
+					Return([]*egoscale.PrivateNetwork{{ID: &testInstancePrivateNetworkID}}, nil)
+
+				ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+					On("GetPrivateNetwork", mock.Anything, testZone, testInstancePrivateNetworkID).
+					Return(&egoscale.PrivateNetwork{
+						ID: &testInstancePrivateNetworkID,
+						Leases: []*egoscale.PrivateNetworkLease{{
+							InstanceID: &testOtherInstanceID,
+							IPAddress:  &testInstanceIPAddress,
+						}},
+					}, nil)
+			},
+			resCheckFunc: func(ts *backendTestSuite, response *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			reqData: map[string]interface{}{
+				authLoginParamRole: testRoleName,
+			},
+			wantErr: true,
+		},
 	}
 
 	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
 		Validator: fmt.Sprintf(
 			`client_ip == instance_public_ip && `+
 				`instance_created > now - duration("10m") && `+
+				`"%s" in instance_anti_affinity_group_names && `+
+				`instance_deploy_target_name == "%s" && `+
+				`instance_ssh_key_name == "%s" && `+
+				`instance_ssh_key_fingerprint == "%s" && `+
 				`instance_id == "%s" && `+
 				`instance_manager_id == "%s" && `+
 				`instance_manager_name == "%s" && `+
+				`instance_manager_description == "%s" && `+
+				`instance_manager_instance_type_id == "%s" && `+
+				`instance_manager_labels["tier"] == "web" && `+
+				`instance_manager_size == %d && `+
+				`instance_manager_state == "%s" && `+
+				`instance_manager_template_id == "%s" && `+
 				`"%s" in instance_security_group_ids && `+
 				`"%s" in instance_security_group_names && `+
 				`instance_labels == {%s} && `+
+				`instance_template_id == "%s" && `+
+				`instance_template_name.startsWith("hardened-") && `+
+				`instance_template_visibility == "%s" && `+
+				`instance_type_family == "%s" && `+
+				`instance_type_size == "%s" && `+
+				`instance_type_cpus == %d && `+
+				`instance_type_memory == %d && `+
 				`instance_zone == "%s"`,
+			testInstanceAAGName,
+			testInstanceDeployTargetName,
+			testInstanceSSHKeyName,
+			testInstanceSSHKeyFingerprint,
 			testInstanceID,
 			testInstancePoolID,
 			testInstancePoolName,
+			testInstancePoolDescription,
+			testInstanceTypeID,
+			testInstancePoolSize,
+			testInstanceState,
+			testInstanceTemplateID,
 			testInstanceSecurityGroupID,
 			testInstanceSecurityGroupName,
 			func() string {
@@ -208,6 +688,12 @@ func (ts *backendTestSuite) TestPathLogin() {
 				}
 				return strings.Join(tags, ",")
 			}(),
+			testInstanceTemplateID,
+			testInstanceTemplateVisibility,
+			testInstanceTypeFamily,
+			testInstanceTypeSize,
+			testInstanceTypeCPUs,
+			testInstanceTypeMemory,
 			testZone,
 		),
 	})
@@ -215,17 +701,23 @@ func (ts *backendTestSuite) TestPathLogin() {
 	for _, tt := range tests {
 		// Reset the Exoscale client mock calls stack between test cases
 		ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).ExpectedCalls = nil
+		ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).Calls = nil
 
 		ts.T().Run(tt.name, func(t *testing.T) {
 			if setup := tt.setupFunc; setup != nil {
 				setup(ts)
 			}
 
+			remoteAddr := testInstanceIPAddress.String()
+			if tt.remoteAddr != "" {
+				remoteAddr = tt.remoteAddr
+			}
+
 			res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
 				Storage:    ts.storage,
 				Operation:  logical.UpdateOperation,
 				Path:       "login",
-				Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+				Connection: &logical.Connection{RemoteAddr: remoteAddr},
 				Data:       tt.reqData,
 			})
 			if err != nil != tt.wantErr {
@@ -237,3 +729,425 @@ func (ts *backendTestSuite) TestPathLogin() {
 		})
 	}
 }
+
+func testInstanceBootstrapSecretHash() string {
+	hash := sha256.Sum256([]byte(testInstanceBootstrapSecret))
+	return hex.EncodeToString(hash[:])
+}
+
+func (ts *backendTestSuite) TestPathLoginKeyBinding() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator:  defaultRoleValidator,
+		KeyBinding: true,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	ts.Require().NoError(err)
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	ts.Require().NoError(err)
+
+	login := func(data map[string]interface{}) (*logical.Response, error) {
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data:       data,
+		})
+	}
+
+	nonce := func() string {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   ts.storage,
+			Operation: logical.UpdateOperation,
+			Path:      "nonce",
+			Data:      map[string]interface{}{nonceKeyInstance: testInstanceID},
+		})
+		ts.Require().NoError(err)
+		return res.Data[nonceKeyNonce].(string)
+	}
+
+	sign := func(key ed25519.PrivateKey, nonce string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(nonce)))
+	}
+
+	// First login: the submitted public key gets bound to the instance.
+	n := nonce()
+	res, err := login(map[string]interface{}{
+		authLoginParamInstance:  testInstanceID,
+		authLoginParamNonce:     n,
+		authLoginParamPublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		authLoginParamRole:      testRoleName,
+		authLoginParamSignature: sign(privateKey, n),
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+	fingerprint := res.Auth.InternalData["key_fingerprint"].(string)
+
+	key, err := ts.backend.(*exoscaleBackend).instanceKey(context.Background(), ts.storage, testInstanceID)
+	ts.Require().NoError(err)
+	ts.Require().NotNil(key)
+	ts.Require().Equal(fingerprint, key.Fingerprint())
+
+	// Subsequent login signed with another key: denied.
+	n = nonce()
+	_, err = login(map[string]interface{}{
+		authLoginParamInstance:  testInstanceID,
+		authLoginParamNonce:     n,
+		authLoginParamRole:      testRoleName,
+		authLoginParamSignature: sign(otherPrivateKey, n),
+	})
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	// Subsequent login signed with the bound key.
+	n = nonce()
+	res, err = login(map[string]interface{}{
+		authLoginParamInstance:  testInstanceID,
+		authLoginParamNonce:     n,
+		authLoginParamRole:      testRoleName,
+		authLoginParamSignature: sign(privateKey, n),
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal(fingerprint, res.Auth.InternalData["key_fingerprint"])
+
+	// Replayed nonce and signature: denied.
+	_, err = login(map[string]interface{}{
+		authLoginParamInstance:  testInstanceID,
+		authLoginParamNonce:     n,
+		authLoginParamRole:      testRoleName,
+		authLoginParamSignature: sign(privateKey, n),
+	})
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+}
+
+func (ts *backendTestSuite) TestPathLoginInstanceName() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+	testDuplicateInstanceName := ts.randomString(10)
+	testDuplicateInstanceID := ts.randomID()
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("ListInstances", mock.Anything, testZone).
+		Return([]*egoscale.Instance{
+			{ID: &testInstanceID, Name: &testInstanceName},
+			{ID: &testOtherInstanceID, Name: &testDuplicateInstanceName},
+			{ID: &testDuplicateInstanceID, Name: &testDuplicateInstanceName},
+		}, nil).
+		Twice()
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	login := func(data map[string]interface{}) (*logical.Response, error) {
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data:       data,
+		})
+	}
+
+	// The second login is served from the instance name lookup cache.
+	for i := 0; i < 2; i++ {
+		res, err := login(map[string]interface{}{
+			authLoginParamInstanceName: testInstanceName,
+			authLoginParamRole:         testRoleName,
+			authLoginParamZone:         testZone,
+		})
+		ts.Require().NoError(err)
+		ts.Require().NotNil(res.Auth)
+		ts.Require().Equal(testInstanceID, res.Auth.InternalData["instance_id"])
+	}
+
+	_, err := login(map[string]interface{}{
+		authLoginParamInstanceName: testDuplicateInstanceName,
+		authLoginParamRole:         testRoleName,
+	})
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	res, err := login(map[string]interface{}{
+		authLoginParamInstanceName: testInstanceName,
+		authLoginParamRole:         testRoleName,
+		authLoginParamZone:         "de-fra-1",
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(strings.Contains(res.Error().Error(), errInvalidFieldValue.Error()))
+
+	res, err = login(map[string]interface{}{
+		authLoginParamInstance:     testInstanceID,
+		authLoginParamInstanceName: testInstanceName,
+		authLoginParamRole:         testRoleName,
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(strings.Contains(res.Error().Error(), errInvalidFieldValue.Error()))
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertExpectations(ts.T())
+}
+
+func (ts *backendTestSuite) TestPathLoginInstanceDiscoveryCache() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("ListInstances", mock.Anything, testZone).
+		Return([]*egoscale.Instance{
+			{ID: &testInstanceID, PublicIPAddress: &testInstanceIPAddress},
+		}, nil).
+		Once()
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("ListElasticIPs", mock.Anything, testZone).
+		Return([]*egoscale.ElasticIP{}, nil).
+		Once()
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("ListPrivateNetworks", mock.Anything, testZone).
+		Return([]*egoscale.PrivateNetwork{}, nil).
+		Once()
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	// The second login is served from the instance IP address lookup cache.
+	for i := 0; i < 2; i++ {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data:       map[string]interface{}{authLoginParamRole: testRoleName},
+		})
+		ts.Require().NoError(err)
+		ts.Require().NotNil(res.Auth)
+		ts.Require().Equal(testInstanceID, res.Auth.InternalData["instance_id"])
+	}
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertExpectations(ts.T())
+}
+
+func (ts *backendTestSuite) TestPathLoginInstanceDiscoveryCacheStale() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+	exo := ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock)
+	exo.On("ListInstances", mock.Anything, testZone).
+		Return([]*egoscale.Instance{
+			{ID: &testInstanceID, PublicIPAddress: &testInstanceIPAddress},
+		}, nil).
+		Once()
+	exo.On("ListInstances", mock.Anything, testZone).
+		Return([]*egoscale.Instance{
+			{ID: &testInstanceID, PublicIPAddress: &testOtherInstanceIPAddress},
+			{ID: &testOtherInstanceID, PublicIPAddress: &testInstanceIPAddress},
+		}, nil).
+		Once()
+	exo.On("ListElasticIPs", mock.Anything, testZone).
+		Return([]*egoscale.ElasticIP{}, nil).
+		Twice()
+	exo.On("ListPrivateNetworks", mock.Anything, testZone).
+		Return([]*egoscale.PrivateNetwork{}, nil).
+		Twice()
+
+	exo.On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil).
+		Once()
+	// The IP address of the instance has since been reassigned.
+	exo.On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			PublicIPAddress: &testOtherInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil).
+		Once()
+	exo.On("GetInstance", mock.Anything, testZone, testOtherInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testOtherInstanceID,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil).
+		Once()
+
+	// The stale cached lookup result is evicted, and the lookup performed again.
+	for _, expected := range []string{testInstanceID, testOtherInstanceID} {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data:       map[string]interface{}{authLoginParamRole: testRoleName},
+		})
+		ts.Require().NoError(err)
+		ts.Require().NotNil(res.Auth)
+		ts.Require().Equal(expected, res.Auth.InternalData["instance_id"])
+	}
+
+	exo.AssertExpectations(ts.T())
+}
+
+func (ts *backendTestSuite) TestPathLoginMultiZone() {
+	testOtherZone := "de-fra-1"
+
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone, Zones: []string{testZone, testOtherZone}})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: defaultRoleValidator})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(new(egoscale.Instance), exoapi.ErrNotFound).
+		Once()
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testOtherZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testOtherZone,
+		}, nil)
+
+	// No zone specified: all configured zones are probed.
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+	ts.Require().Equal(testOtherZone, res.Auth.InternalData["zone"])
+
+	// Renewal only checks the zone the instance was found in.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.RenewOperation,
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Auth:       &logical.Auth{InternalData: res.Auth.InternalData},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	// Zone specified: only this zone is checked.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+			authLoginParamZone:     testOtherZone,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	// Zone not configured in the backend.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+			authLoginParamZone:     "at-vie-1",
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(strings.Contains(res.Error().Error(), errInvalidFieldValue.Error()))
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertExpectations(ts.T())
+}
+
+func (ts *backendTestSuite) TestPathLoginLazyValidatorVars() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator: roleValidatorVarInstanceState + ` == "` + instanceStateRunning + `" && ` +
+			roleValidatorVarInstanceManagerName + ` == "` + testInstancePoolName + `"`,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:         &testInstanceCreated,
+			ID:                &testInstanceID,
+			InstanceTypeID:    &testInstanceTypeID,
+			Manager:           &egoscale.InstanceManager{ID: testInstancePoolID, Type: "instance-pool"},
+			Name:              &testInstanceName,
+			PrivateNetworkIDs: &[]string{testInstancePrivateNetworkID},
+			PublicIPAddress:   &testInstanceIPAddress,
+			SecurityGroupIDs:  &[]string{testInstanceSecurityGroupID},
+			State:             &testInstanceState,
+			TemplateID:        &testInstanceTemplateID,
+			Zone:              &testZone,
+		}, nil)
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
+		Return(&egoscale.InstancePool{
+			ID:   &testInstancePoolID,
+			Name: &testInstancePoolName,
+		}, nil).
+		Once()
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:    ts.storage,
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+		Data: map[string]interface{}{
+			authLoginParamInstance: testInstanceID,
+			authLoginParamRole:     testRoleName,
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	// Only the resources referenced by the role validator are retrieved.
+	for _, method := range []string{"GetInstanceType", "GetPrivateNetwork", "GetSecurityGroup", "GetTemplate"} {
+		ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertNumberOfCalls(ts.T(), method, 0)
+	}
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).AssertExpectations(ts.T())
+}
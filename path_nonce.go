@@ -0,0 +1,265 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	nonceKeyStoragePath        = "nonce-key"
+	usedNonceStoragePathPrefix = "used-nonce/"
+
+	nonceKeyExpires  = "expires"
+	nonceKeyInstance = "instance"
+	nonceKeyNonce    = "nonce"
+
+	nonceTTL = 5 * time.Minute
+)
+
+var (
+	pathNonceHelpSyn  = "Issue a login nonce for an Exoscale Compute Instance"
+	pathNonceHelpDesc = `
+This endpoint issues a short-lived nonce bound to a Compute instance ID, to be
+signed by the instance private key when logging in using a role with
+trust-on-first-use key binding enabled. A nonce can only be used for one
+successful signature verification.
+`
+
+	errInvalidNonce = errors.New("invalid nonce")
+)
+
+func pathNonce(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "nonce",
+		Fields: map[string]*framework.FieldSchema{
+			nonceKeyInstance: {
+				Type:        framework.TypeString,
+				Description: "Instance ID",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathNonceWrite},
+		},
+
+		HelpSynopsis:    pathNonceHelpSyn,
+		HelpDescription: pathNonceHelpDesc,
+	}
+}
+
+func (b *exoscaleBackend) pathNonceWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	instanceID := data.Get(nonceKeyInstance).(string)
+	if instanceID == "" {
+		return logical.ErrorResponse("%v: %s", errMissingField, nonceKeyInstance), nil
+	}
+
+	key, err := b.nonceKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	expires := time.Now().Add(nonceTTL)
+	payload := strings.Join([]string{
+		instanceID,
+		strconv.FormatInt(expires.Unix(), 10),
+		hex.EncodeToString(random),
+	}, "|")
+
+	return &logical.Response{Data: map[string]interface{}{
+		nonceKeyExpires: expires.Format(time.RFC3339),
+		nonceKeyNonce: base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+			base64.RawURLEncoding.EncodeToString(nonceMAC(key, payload)),
+	}}, nil
+}
+
+// nonceKey returns the secret key used to authenticate the nonces issued by
+// the backend. The key is generated when the backend is configured; for
+// backends configured with a previous version, it is generated upon first
+// use, which performance standbys forward to the active node.
+func (b *exoscaleBackend) nonceKey(ctx context.Context, storage logical.Storage) ([]byte, error) {
+	b.nonceKeyLock.Lock()
+	defer b.nonceKeyLock.Unlock()
+
+	if b.nonceKeyCache != nil {
+		return b.nonceKeyCache, nil
+	}
+
+	entry, err := storage.Get(ctx, nonceKeyStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve nonce key: %w", err)
+	}
+
+	if entry != nil {
+		b.nonceKeyCache = entry.Value
+		return b.nonceKeyCache, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce key: %w", err)
+	}
+
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: nonceKeyStoragePath, Value: key}); err != nil {
+		if isReadOnlyErr(err) {
+			return nil, logical.ErrReadOnly
+		}
+		return nil, fmt.Errorf("unable to store nonce key: %w", err)
+	}
+	b.nonceKeyCache = key
+
+	return b.nonceKeyCache, nil
+}
+
+// checkNonce verifies that nonce has been issued by the backend for the
+// specified instance and has not expired, and returns its expiration time.
+func (b *exoscaleBackend) checkNonce(
+	ctx context.Context,
+	storage logical.Storage,
+	nonce, instanceID string,
+) (time.Time, error) {
+	key, err := b.nonceKey(ctx, storage)
+	if err != nil {
+		if errors.Is(err, logical.ErrReadOnly) {
+			return time.Time{}, err
+		}
+		return time.Time{}, fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	parts := strings.SplitN(nonce, ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, errInvalidNonce
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return time.Time{}, errInvalidNonce
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, errInvalidNonce
+	}
+
+	if !hmac.Equal(mac, nonceMAC(key, string(payload))) {
+		return time.Time{}, errInvalidNonce
+	}
+
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 3 || fields[0] != instanceID {
+		return time.Time{}, errInvalidNonce
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return time.Time{}, errInvalidNonce
+	}
+	if time.Now().After(time.Unix(expires, 0)) {
+		return time.Time{}, fmt.Errorf("%w: expired", errInvalidNonce)
+	}
+
+	return time.Unix(expires, 0), nil
+}
+
+// consumeNonce records a nonce as used until its expiration, so that a nonce
+// and its signature cannot be replayed. It fails if the nonce has already
+// been used.
+func (b *exoscaleBackend) consumeNonce(
+	ctx context.Context,
+	storage logical.Storage,
+	nonce string,
+	expires time.Time,
+) error {
+	sum := sha256.Sum256([]byte(nonce))
+	path := usedNonceStoragePathPrefix + hex.EncodeToString(sum[:])
+
+	b.usedNoncesLock.Lock()
+	defer b.usedNoncesLock.Unlock()
+
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("%w: unable to retrieve used nonce: %v", errInternalError, err) // nolint:errorlint
+	}
+	if entry != nil {
+		return fmt.Errorf("%w: %v: already used", errAuthFailed, errInvalidNonce) // nolint:errorlint
+	}
+
+	if entry, err = logical.StorageEntryJSON(path, expires); err != nil {
+		return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		if isReadOnlyErr(err) {
+			return logical.ErrReadOnly
+		}
+		return fmt.Errorf("%w: unable to store used nonce: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	return nil
+}
+
+// tidyUsedNonces deletes the used nonces records which have expired, as the
+// nonces they record can no longer be used anyway.
+func (b *exoscaleBackend) tidyUsedNonces(ctx context.Context, storage logical.Storage) error {
+	b.usedNoncesLock.Lock()
+	defer b.usedNoncesLock.Unlock()
+
+	keys, err := storage.List(ctx, usedNonceStoragePathPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to list used nonces: %w", err)
+	}
+
+	for _, k := range keys {
+		entry, err := storage.Get(ctx, usedNonceStoragePathPrefix+k)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve used nonce: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		var expires time.Time
+		if err := entry.DecodeJSON(&expires); err == nil && time.Now().Before(expires) {
+			continue
+		}
+
+		if err := storage.Delete(ctx, usedNonceStoragePathPrefix+k); err != nil {
+			return fmt.Errorf("unable to delete used nonce: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isReadOnlyErr reports whether err is returned by read-only storage (e.g. on
+// performance standbys). Storage errors are passed as plain messages through
+// the plugin RPC layer. Returning logical.ErrReadOnly as-is to Vault makes it
+// forward the request to the active node.
+func isReadOnlyErr(err error) bool {
+	return errors.Is(err, logical.ErrReadOnly) || err.Error() == logical.ErrReadOnly.Error()
+}
+
+func nonceMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
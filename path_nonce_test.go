@@ -0,0 +1,54 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (ts *backendTestSuite) TestTidyUsedNonces() {
+	ts.storeEntry(usedNonceStoragePathPrefix+"expired", time.Now().Add(-time.Minute))
+	ts.storeEntry(usedNonceStoragePathPrefix+"valid", time.Now().Add(time.Minute))
+
+	err := ts.backend.(*exoscaleBackend).periodicFunc(context.Background(), &logical.Request{Storage: ts.storage})
+	ts.Require().NoError(err)
+
+	keys, err := ts.storage.List(context.Background(), usedNonceStoragePathPrefix)
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{"valid"}, keys)
+}
+
+// readOnlyStorage mimics the storage of performance standbys, which return
+// plain logical.ErrReadOnly messages through the plugin RPC layer.
+type readOnlyStorage struct {
+	logical.Storage
+}
+
+func (s readOnlyStorage) Put(context.Context, *logical.StorageEntry) error {
+	return errors.New(logical.ErrReadOnly.Error())
+}
+
+func (ts *backendTestSuite) TestPathNonceReadOnlyStorage() {
+	// Generating the nonce key of backends configured with a previous
+	// version on a performance standby requires forwarding to the active node.
+	_, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   readOnlyStorage{ts.storage},
+		Operation: logical.UpdateOperation,
+		Path:      "nonce",
+		Data:      map[string]interface{}{nonceKeyInstance: testInstanceID},
+	})
+	ts.Require().Equal(logical.ErrReadOnly, err)
+
+	ts.storeEntry(nonceKeyStoragePath, "key")
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   readOnlyStorage{ts.storage},
+		Operation: logical.UpdateOperation,
+		Path:      "nonce",
+		Data:      map[string]interface{}{nonceKeyInstance: testInstanceID},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotEmpty(res.Data[nonceKeyNonce])
+}
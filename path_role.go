@@ -2,17 +2,21 @@ package exoscale
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/ext"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 
 	egoscale "github.com/exoscale/egoscale/v2"
 )
@@ -20,41 +24,95 @@ import (
 const (
 	roleStoragePathPrefix = "role/"
 
-	roleKeyName      = "name"
-	roleKeyValidator = "validator"
+	roleKeyAccounts              = "accounts"
+	roleKeyAuthType              = "auth_type"
+	roleKeyBindSecretID          = "bind_secret_id"
+	roleKeyBootstrapSecretLabel  = "bootstrap_secret_label"
+	roleKeyBootstrapSecretSource = "bootstrap_secret_source"
+	roleKeyBoundIAMAccessKeys    = "bound_iam_access_keys"
+	roleKeyBoundIAMKeyNames      = "bound_iam_access_key_names"
+	roleKeyBoundIAMRoleIDs       = "bound_iam_role_ids"
+	roleKeyKeyBinding            = "key_binding"
+	roleKeyName                  = "name"
+	roleKeySKSAudience           = "sks_audience"
+	roleKeySKSClusterID          = "sks_cluster_id"
+	roleKeyValidator             = "validator"
+	roleKeyValidatorMode         = "validator_mode"
+
+	roleAuthTypeIAM      = "iam"
+	roleAuthTypeInstance = "instance"
+
+	roleBootstrapSecretSourceLabel    = "label"
+	roleBootstrapSecretSourceNone     = ""
+	roleBootstrapSecretSourceUserData = "user-data"
+
+	defaultRoleBootstrapSecretLabel = "vault-bootstrap-secret-sha256"
 
 	roleValidatorVarClientIP                   = "client_ip"
+	roleValidatorVarInstanceAAGIDs             = "instance_anti_affinity_group_ids"
+	roleValidatorVarInstanceAAGNames           = "instance_anti_affinity_group_names"
 	roleValidatorVarInstanceCreated            = "instance_created"
+	roleValidatorVarInstanceDeployTargetID     = "instance_deploy_target_id"
+	roleValidatorVarInstanceDeployTargetName   = "instance_deploy_target_name"
+	roleValidatorVarInstanceElasticIPs         = "instance_elastic_ips"
 	roleValidatorVarInstanceID                 = "instance_id"
 	roleValidatorVarInstanceManager            = "instance_manager"
+	roleValidatorVarInstanceManagerDescription = "instance_manager_description"
 	roleValidatorVarInstanceManagerID          = "instance_manager_id"
+	roleValidatorVarInstanceManagerInstType    = "instance_manager_instance_type_id"
+	roleValidatorVarInstanceManagerLabels      = "instance_manager_labels"
 	roleValidatorVarInstanceManagerName        = "instance_manager_name"
+	roleValidatorVarInstanceManagerSize        = "instance_manager_size"
+	roleValidatorVarInstanceManagerState       = "instance_manager_state"
+	roleValidatorVarInstanceManagerTemplateID  = "instance_manager_template_id"
 	roleValidatorVarInstanceName               = "instance_name"
+	roleValidatorVarInstancePrivateIPs         = "instance_private_ips"
 	roleValidatorVarInstancePublicIP           = "instance_public_ip"
+	roleValidatorVarInstancePublicIPv6         = "instance_public_ipv6"
 	roleValidatorVarInstanceSecurityGroupIDs   = "instance_security_group_ids"
 	roleValidatorVarInstanceSecurityGroupNames = "instance_security_group_names"
+	roleValidatorVarInstanceSKSClusterID       = "instance_sks_cluster_id"
+	roleValidatorVarInstanceSKSClusterName     = "instance_sks_cluster_name"
+	roleValidatorVarInstanceSKSNodepoolName    = "instance_sks_nodepool_name"
+	roleValidatorVarInstanceSSHKeyFingerprint  = "instance_ssh_key_fingerprint"
+	roleValidatorVarInstanceSSHKeyName         = "instance_ssh_key_name"
+	roleValidatorVarInstanceState              = "instance_state"
+	roleValidatorVarInstanceTemplateID         = "instance_template_id"
+	roleValidatorVarInstanceTemplateName       = "instance_template_name"
+	roleValidatorVarInstanceTemplateVisibility = "instance_template_visibility"
+	roleValidatorVarInstanceTypeCPUs           = "instance_type_cpus"
+	roleValidatorVarInstanceTypeFamily         = "instance_type_family"
+	roleValidatorVarInstanceTypeMemory         = "instance_type_memory"
+	roleValidatorVarInstanceTypeSize           = "instance_type_size"
 	roleValidatorVarInstanceLabels             = "instance_labels"
 	roleValidatorVarInstanceZone               = "instance_zone"
 	roleValidatorVarNow                        = "now"
+	roleValidatorVarSAName                     = "sa_name"
+	roleValidatorVarSANamespace                = "sa_namespace"
 
-	defaultRoleValidator = roleValidatorVarClientIP + " == " + roleValidatorVarInstancePublicIP
+	roleValidatorModePrivateIP = "private-ip"
+	roleValidatorModePublicIP  = "public-ip"
+
+	instanceStateRunning = "running"
+
+	defaultRoleValidator = roleValidatorVarInstanceState + ` == "` + instanceStateRunning + `" && (` +
+		roleValidatorVarClientIP + " == " + roleValidatorVarInstancePublicIP + " || (" +
+		roleValidatorVarInstancePublicIPv6 + ` != "" && ` +
+		roleValidatorVarClientIP + " == " + roleValidatorVarInstancePublicIPv6 + ") || " +
+		roleValidatorVarClientIP + " in " + roleValidatorVarInstanceElasticIPs + ")"
+
+	privateIPRoleValidator = roleValidatorVarInstanceState + ` == "` + instanceStateRunning + `" && ` +
+		roleValidatorVarInstancePrivateIPs + ".exists(n, " +
+		roleValidatorVarInstancePrivateIPs + "[n] == " + roleValidatorVarClientIP + ")"
 )
 
 var (
-	roleValidatorsVars = map[string]string{
-		roleValidatorVarClientIP:                   "IP address of the Vault client (string)",
-		roleValidatorVarInstanceCreated:            "creation date of the instance (timestamp)",
-		roleValidatorVarInstanceID:                 "ID of the instance (string)",
-		roleValidatorVarInstanceManager:            "type of the instance manager, if any (string)",
-		roleValidatorVarInstanceManagerID:          "ID of the instance manager, if any (string)",
-		roleValidatorVarInstanceManagerName:        "name of the instance manager, if any (string)",
-		roleValidatorVarInstanceName:               "name of the instance (string)",
-		roleValidatorVarInstancePublicIP:           "public IPv4 address of the instance (string)",
-		roleValidatorVarInstanceSecurityGroupIDs:   "list of Security Group IDs the instance belongs to (list of strings)",
-		roleValidatorVarInstanceSecurityGroupNames: "list of Security Group names the instance belongs to (list of strings)",
-		roleValidatorVarInstanceLabels:             "map of instance labels (map[string]string)",
-		roleValidatorVarInstanceZone:               "name of the instance's zone (string)",
-		roleValidatorVarNow:                        "current timestamp (timestamp)",
+	// roleValidatorModes are built-in validation expressions, which can be
+	// selected by name using the "validator_mode" role parameter instead of
+	// supplying a custom validator expression.
+	roleValidatorModes = map[string]string{
+		roleValidatorModePrivateIP: privateIPRoleValidator,
+		roleValidatorModePublicIP:  defaultRoleValidator,
 	}
 
 	pathListRolesHelpSyn  = "List the configured backend roles"
@@ -78,103 +136,161 @@ following expression is set by default:
 
   %s
 
+Alternatively, one of the following built-in validation expressions can be
+selected using the "validator_mode" role parameter:
+
+%s
+
+In addition to the CEL standard functions, validator expressions can use the
+ip(string) and cidr(string) functions to handle IP addresses and networks
+(e.g. cidr("10.0.0.0/8").contains(ip(client_ip)), ip(client_ip).is_ipv6()),
+the <string>.matches_glob(pattern) function, as well as the functions of the
+CEL strings extension (charAt, indexOf, lastIndexOf, lowerAscii, replace,
+split, substring, trim, upperAscii).
+
+Optionally, a role can require clients to supply a bootstrap secret during
+login, which is checked against a SHA-256 hash (hex-encoded) set by the
+operator on the Compute instance. The "bootstrap_secret_source" role parameter
+specifies where the hash is looked up:
+
+  * user-data: in the instance user data, in a line formatted as
+    "%s <hash>"
+  * label: in the instance label specified by the "bootstrap_secret_label"
+    role parameter (default: "%s")
+
+If "key_binding" is enabled, logins must be signed using a key bound to the
+instance on first use. Token renewals are not signed, as Vault only passes the
+token to the auth method upon renewal: a renewal is only refused if the key
+bound to the instance has been reset or changed since the token was issued.
+Tokens of such roles must therefore be protected as any other token, and
+should be given a "token_max_ttl" to bound their lifetime.
+
 [0]: https://github.com/google/cel-spec
 `, func() string {
-		var (
-			vars = make([]string, 0)
-			out  strings.Builder
-		)
+		var out strings.Builder
 
-		for k := range roleValidatorsVars {
-			vars = append(vars, k)
-		}
-		sort.Strings(vars)
-		for _, v := range vars {
-			_, _ = fmt.Fprintf(&out, "  * %s: %s\n", v, roleValidatorsVars[v])
+		for _, v := range roleValidatorVarProviders {
+			_, _ = fmt.Fprintf(&out, "  * %s: %s\n", v.name, v.description)
 		}
 
 		return out.String()
 	}(),
 		defaultRoleValidator,
+		func() string {
+			var (
+				modes = make([]string, 0)
+				out   strings.Builder
+			)
+
+			for k := range roleValidatorModes {
+				modes = append(modes, k)
+			}
+			sort.Strings(modes)
+			for _, m := range modes {
+				_, _ = fmt.Fprintf(&out, "  * %s: %s\n", m, roleValidatorModes[m])
+			}
+
+			return out.String()
+		}(),
+		bootstrapSecretUserDataMarker,
+		defaultRoleBootstrapSecretLabel,
 	)
 )
 
+// celProgramCacheEntry represents the compiled validator program of a role.
+type celProgramCacheEntry struct {
+	validatorHash [sha256.Size]byte
+	program       *roleValidatorProgram
+}
+
 type backendRole struct {
-	Validator string `json:"validator"`
+	AuthType              string `json:"auth_type,omitempty"`
+	Validator             string `json:"validator"`
+	BootstrapSecretSource string `json:"bootstrap_secret_source,omitempty"`
+	BootstrapSecretLabel  string `json:"bootstrap_secret_label,omitempty"`
+	KeyBinding            bool   `json:"key_binding,omitempty"`
+	BindSecretID          bool   `json:"bind_secret_id,omitempty"`
+	SKSClusterID          string `json:"sks_cluster_id,omitempty"`
+	SKSAudience           string `json:"sks_audience,omitempty"`
+
+	Accounts []string `json:"accounts,omitempty"`
+
+	BoundIAMAccessKeys []string `json:"bound_iam_access_keys,omitempty"`
+	BoundIAMKeyNames   []string `json:"bound_iam_access_key_names,omitempty"`
+	BoundIAMRoleIDs    []string `json:"bound_iam_role_ids,omitempty"`
 
 	tokenutil.TokenParams
 }
 
 func (b *exoscaleBackend) checkInstanceRole(
 	ctx context.Context,
-	req *logical.Request,
+	exo exoscaleClient,
+	clientIP string,
 	instance *egoscale.Instance,
+	serviceAccount *sksServiceAccount,
+	roleName string,
 	role *backendRole,
+	concurrency int,
 ) error {
-	p, err := buildCELProgram(role.Validator)
+	p, err := b.roleCELProgram(roleName, role)
 	if err != nil {
 		return err
 	}
 
-	labels := make(map[string]string)
-	if instance.Labels != nil {
-		for k, v := range *instance.Labels {
-			labels[k] = v
-		}
-	}
-
-	var managerType, managerID, managerName string
-	if instance.Manager != nil {
-		managerType = instance.Manager.Type
-		managerID = instance.Manager.ID
-		switch instance.Manager.Type {
-		case "instance-pool":
-			instancePool, err := b.exo.GetInstancePool(ctx, *instance.Zone, managerID)
-			if err != nil {
-				return fmt.Errorf("unable to retrieve Instance Pool %q: %w", managerID, err)
-			}
-			managerName = *instancePool.Name
+	env := newRoleValidatorEnv(ctx, exo, clientIP, instance, serviceAccount, concurrency)
+	defer env.close()
 
-		default:
-		}
+	result, err := p.eval(env)
+	if err != nil {
+		return err
 	}
 
-	sgNames := make([]string, 0)
-	sgIDs := make([]string, 0)
-	if instance.SecurityGroupIDs != nil {
-		for _, id := range *instance.SecurityGroupIDs {
-			sg, err := b.exo.GetSecurityGroup(ctx, *instance.Zone, id)
-			if err != nil {
-				return fmt.Errorf("unable to retrieve Security Group %q: %w", id, err)
-			}
-			sgIDs = append(sgIDs, *sg.ID)
-			sgNames = append(sgNames, *sg.Name)
-		}
+	if !result.value {
+		return &roleValidationError{denials: p.denials(result.state)}
 	}
 
-	evalContext := map[string]interface{}{
-		roleValidatorVarClientIP:                   req.Connection.RemoteAddr,
-		roleValidatorVarInstanceCreated:            *instance.CreatedAt,
-		roleValidatorVarInstanceID:                 *instance.ID,
-		roleValidatorVarInstanceManager:            managerType,
-		roleValidatorVarInstanceManagerID:          managerID,
-		roleValidatorVarInstanceManagerName:        managerName,
-		roleValidatorVarInstanceName:               instance.Name,
-		roleValidatorVarInstancePublicIP:           instance.PublicIPAddress.String(),
-		roleValidatorVarInstanceSecurityGroupIDs:   sgIDs,
-		roleValidatorVarInstanceSecurityGroupNames: sgNames,
-		roleValidatorVarInstanceLabels:             labels,
-		roleValidatorVarInstanceZone:               instance.Zone,
-		roleValidatorVarNow:                        time.Now(),
+	return nil
+}
+
+// canonicalIP returns the canonical textual representation of the IP address
+// s (e.g. lowercase, zero-compressed for IPv6 addresses, dotted-decimal for
+// IPv4-mapped IPv6 addresses), or s as-is if it cannot be parsed as an IP address.
+func canonicalIP(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
 	}
 
-	result, _, err := p.Eval(evalContext)
-	if err != nil {
-		return err
+	return s
+}
+
+// ipString returns the canonical textual representation of ip, or an empty
+// string if ip is not set.
+func ipString(ip *net.IP) string {
+	if ip == nil || *ip == nil {
+		return ""
 	}
 
-	if success := result.Value().(bool); !success {
-		return fmt.Errorf("%w: role validation failed", errAuthFailed)
+	return ip.String()
+}
+
+// checkIAMAccessKey verifies that an IAM access key identity matches all the
+// IAM bindings set on the role.
+func (r *backendRole) checkIAMAccessKey(accessKey *iamAccessKey) error {
+	for _, binding := range []struct {
+		name   string
+		values []string
+		value  string
+	}{
+		{roleKeyBoundIAMAccessKeys, r.BoundIAMAccessKeys, accessKey.Key},
+		{roleKeyBoundIAMKeyNames, r.BoundIAMKeyNames, accessKey.Name},
+		{roleKeyBoundIAMRoleIDs, r.BoundIAMRoleIDs, accessKey.RoleID},
+	} {
+		if len(binding.values) > 0 && !strutil.StrListContains(binding.values, binding.value) {
+			return fmt.Errorf("%w: IAM access key %s doesn't match role %s",
+				errAuthFailed,
+				accessKey.Key,
+				binding.name)
+		}
 	}
 
 	return nil
@@ -208,6 +324,71 @@ func pathRole(b *exoscaleBackend) *framework.Path {
 				Default:     defaultRoleValidator,
 				Required:    true,
 			},
+			roleKeyAccounts: {
+				Type: framework.TypeCommaStringSlice,
+				Description: "List of Exoscale accounts (configured under \"config/account/\") to look up " +
+					"instances in (default: account of the backend root configuration)",
+			},
+			roleKeyAuthType: {
+				Type:        framework.TypeString,
+				Description: "Type of clients authenticated by the role (\"instance\" or \"iam\")",
+				Default:     roleAuthTypeInstance,
+				AllowedValues: []interface{}{
+					roleAuthTypeInstance,
+					roleAuthTypeIAM,
+				},
+			},
+			roleKeyBoundIAMAccessKeys: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of IAM access keys allowed to authenticate (\"iam\" roles only)",
+			},
+			roleKeyBoundIAMKeyNames: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of IAM access key names allowed to authenticate (\"iam\" roles only)",
+			},
+			roleKeyBoundIAMRoleIDs: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List of IAM role IDs allowed to authenticate (\"iam\" roles only)",
+			},
+			roleKeySKSAudience: {
+				Type:        framework.TypeString,
+				Description: "Audience required in Kubernetes service account tokens (SKS roles only)",
+			},
+			roleKeySKSClusterID: {
+				Type:        framework.TypeString,
+				Description: "ID of the SKS cluster issuing the Kubernetes service account tokens",
+			},
+			roleKeyValidatorMode: {
+				Type:        framework.TypeString,
+				Description: "Name of a built-in validation expression to use instead of a custom validator",
+				AllowedValues: []interface{}{
+					roleValidatorModePrivateIP,
+					roleValidatorModePublicIP,
+				},
+			},
+			roleKeyBootstrapSecretSource: {
+				Type: framework.TypeString,
+				Description: "Location of the instance bootstrap secret hash to check during login " +
+					"(\"user-data\" or \"label\"; empty to disable)",
+				AllowedValues: []interface{}{
+					roleBootstrapSecretSourceNone,
+					roleBootstrapSecretSourceLabel,
+					roleBootstrapSecretSourceUserData,
+				},
+			},
+			roleKeyBootstrapSecretLabel: {
+				Type:        framework.TypeString,
+				Description: "Name of the instance label containing the bootstrap secret hash",
+				Default:     defaultRoleBootstrapSecretLabel,
+			},
+			roleKeyKeyBinding: {
+				Type:        framework.TypeBool,
+				Description: "Require instances to sign login nonces using a key bound on first use (token renewals are not signed)",
+			},
+			roleKeyBindSecretID: {
+				Type:        framework.TypeBool,
+				Description: "Require instances to supply a secret ID generated for the role during login",
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -273,7 +454,32 @@ func (b *exoscaleBackend) readRole(
 	}
 
 	d := map[string]interface{}{
-		roleKeyValidator: role.Validator,
+		roleKeyValidator:             role.Validator,
+		roleKeyBootstrapSecretSource: role.BootstrapSecretSource,
+		roleKeyKeyBinding:            role.KeyBinding,
+		roleKeyBindSecretID:          role.BindSecretID,
+	}
+
+	if len(role.Accounts) > 0 {
+		d[roleKeyAccounts] = role.Accounts
+	}
+
+	if role.SKSClusterID != "" {
+		d[roleKeySKSClusterID] = role.SKSClusterID
+		d[roleKeySKSAudience] = role.SKSAudience
+	}
+
+	if role.AuthType == roleAuthTypeIAM {
+		d[roleKeyAuthType] = roleAuthTypeIAM
+		d[roleKeyBoundIAMAccessKeys] = role.BoundIAMAccessKeys
+		d[roleKeyBoundIAMKeyNames] = role.BoundIAMKeyNames
+		d[roleKeyBoundIAMRoleIDs] = role.BoundIAMRoleIDs
+	} else {
+		d[roleKeyAuthType] = roleAuthTypeInstance
+	}
+
+	if role.BootstrapSecretSource == roleBootstrapSecretSourceLabel {
+		d[roleKeyBootstrapSecretLabel] = role.BootstrapSecretLabel
 	}
 
 	role.PopulateTokenData(d)
@@ -293,10 +499,24 @@ func (b *exoscaleBackend) writeRole(
 		return nil, err
 	}
 	if role == nil {
-		role = &backendRole{}
+		role = &backendRole{Validator: defaultRoleValidator}
 	}
 
-	role.Validator = data.Get(roleKeyValidator).(string)
+	if v, ok := data.GetOk(roleKeyValidator); ok {
+		role.Validator = v.(string)
+	}
+	if v, ok := data.GetOk(roleKeyValidatorMode); ok {
+		if _, ok := data.GetOk(roleKeyValidator); ok {
+			return logical.ErrorResponse("%q and %q parameters are mutually exclusive",
+				roleKeyValidator, roleKeyValidatorMode), nil
+		}
+
+		validator, ok := roleValidatorModes[v.(string)]
+		if !ok {
+			return logical.ErrorResponse("%v: %s", errInvalidFieldValue, roleKeyValidatorMode), nil
+		}
+		role.Validator = validator
+	}
 	if _, err = buildCELProgram(role.Validator); err != nil {
 		if errors.Is(err, errInvalidFieldValue) {
 			return logical.ErrorResponse(err.Error()), nil
@@ -304,6 +524,97 @@ func (b *exoscaleBackend) writeRole(
 		return nil, err
 	}
 
+	if v, ok := data.GetOk(roleKeyBootstrapSecretSource); ok {
+		role.BootstrapSecretSource = v.(string)
+	}
+	switch role.BootstrapSecretSource {
+	case roleBootstrapSecretSourceNone, roleBootstrapSecretSourceUserData:
+		role.BootstrapSecretLabel = ""
+
+	case roleBootstrapSecretSourceLabel:
+		if v, ok := data.GetOk(roleKeyBootstrapSecretLabel); ok {
+			role.BootstrapSecretLabel = v.(string)
+		} else if role.BootstrapSecretLabel == "" {
+			role.BootstrapSecretLabel = defaultRoleBootstrapSecretLabel
+		}
+
+	default:
+		return logical.ErrorResponse(
+			"%v: %s", errInvalidFieldValue, roleKeyBootstrapSecretSource), nil
+	}
+
+	if v, ok := data.GetOk(roleKeyKeyBinding); ok {
+		role.KeyBinding = v.(bool)
+	}
+
+	if v, ok := data.GetOk(roleKeyBindSecretID); ok {
+		role.BindSecretID = v.(bool)
+	}
+
+	if v, ok := data.GetOk(roleKeyAccounts); ok {
+		role.Accounts = v.([]string)
+	}
+	for _, account := range role.Accounts {
+		a, err := b.accountConfig(ctx, req.Storage, account)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil {
+			return logical.ErrorResponse("%v: %s: account %q not found",
+				errInvalidFieldValue,
+				roleKeyAccounts,
+				account), nil
+		}
+	}
+
+	if v, ok := data.GetOk(roleKeySKSClusterID); ok {
+		role.SKSClusterID = v.(string)
+	}
+	if v, ok := data.GetOk(roleKeySKSAudience); ok {
+		role.SKSAudience = v.(string)
+	}
+
+	if v, ok := data.GetOk(roleKeyAuthType); ok {
+		role.AuthType = v.(string)
+	}
+	switch role.AuthType {
+	case "", roleAuthTypeInstance:
+		// Instance roles are stored without explicit type for backward compatibility.
+		role.AuthType = ""
+		role.BoundIAMAccessKeys = nil
+		role.BoundIAMKeyNames = nil
+		role.BoundIAMRoleIDs = nil
+
+	case roleAuthTypeIAM:
+		if v, ok := data.GetOk(roleKeyBoundIAMAccessKeys); ok {
+			role.BoundIAMAccessKeys = v.([]string)
+		}
+		if v, ok := data.GetOk(roleKeyBoundIAMKeyNames); ok {
+			role.BoundIAMKeyNames = v.([]string)
+		}
+		if v, ok := data.GetOk(roleKeyBoundIAMRoleIDs); ok {
+			role.BoundIAMRoleIDs = v.([]string)
+		}
+
+		if len(role.BoundIAMAccessKeys) == 0 && len(role.BoundIAMKeyNames) == 0 && len(role.BoundIAMRoleIDs) == 0 {
+			return logical.ErrorResponse("%v: at least one of %q, %q or %q must be set for %q roles",
+				errMissingField,
+				roleKeyBoundIAMAccessKeys,
+				roleKeyBoundIAMKeyNames,
+				roleKeyBoundIAMRoleIDs,
+				roleAuthTypeIAM), nil
+		}
+
+		if role.BootstrapSecretSource != roleBootstrapSecretSourceNone || role.KeyBinding || role.BindSecretID ||
+			role.SKSClusterID != "" || len(role.Accounts) > 0 {
+			return logical.ErrorResponse("%q roles don't support instance bootstrap secrets, key binding, "+
+				"secret IDs, SKS cluster or account binding", roleAuthTypeIAM), nil
+		}
+
+	default:
+		return logical.ErrorResponse("%v: %s", errInvalidFieldValue, roleKeyAuthType), nil
+	}
+
 	b.Logger().Debug(
 		fmt.Sprintf("creating role %q", name),
 		"validator", role.Validator,
@@ -322,6 +633,8 @@ func (b *exoscaleBackend) writeRole(
 		return nil, err
 	}
 
+	b.forgetRoleCELProgram(name)
+
 	return nil, nil
 }
 
@@ -335,25 +648,63 @@ func (b *exoscaleBackend) deleteRole(
 		return nil, err
 	}
 
+	if err := b.deleteRoleSecretIDs(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
+
+	b.forgetRoleCELProgram(name)
+
 	return nil, nil
 }
 
-func buildCELProgram(expression string) (cel.Program, error) {
-	env, err := cel.NewEnv(cel.Declarations(
-		decls.NewVar(roleValidatorVarClientIP, decls.String),
-		decls.NewVar(roleValidatorVarInstanceCreated, decls.Timestamp),
-		decls.NewVar(roleValidatorVarInstanceID, decls.String),
-		decls.NewVar(roleValidatorVarInstanceManager, decls.String),
-		decls.NewVar(roleValidatorVarInstanceManagerID, decls.String),
-		decls.NewVar(roleValidatorVarInstanceManagerName, decls.String),
-		decls.NewVar(roleValidatorVarInstanceName, decls.String),
-		decls.NewVar(roleValidatorVarInstancePublicIP, decls.String),
-		decls.NewVar(roleValidatorVarInstanceSecurityGroupIDs, decls.NewListType(decls.String)),
-		decls.NewVar(roleValidatorVarInstanceSecurityGroupNames, decls.NewListType(decls.String)),
-		decls.NewVar(roleValidatorVarInstanceLabels, decls.NewMapType(decls.String, decls.String)),
-		decls.NewVar(roleValidatorVarInstanceZone, decls.String),
-		decls.NewVar(roleValidatorVarNow, decls.Timestamp),
-	))
+// roleCELProgram returns the compiled validator program of the role. Programs
+// are cached by role name and validator expression hash, so that the
+// expression is only parsed and type-checked once.
+func (b *exoscaleBackend) roleCELProgram(roleName string, role *backendRole) (*roleValidatorProgram, error) {
+	hash := sha256.Sum256([]byte(role.Validator))
+
+	b.celProgramsLock.Lock()
+	defer b.celProgramsLock.Unlock()
+
+	if entry, ok := b.celProgramsCache[roleName]; ok && entry.validatorHash == hash {
+		return entry.program, nil
+	}
+
+	p, err := buildCELProgram(role.Validator)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.celProgramsCache == nil {
+		b.celProgramsCache = make(map[string]*celProgramCacheEntry)
+	}
+	b.celProgramsCache[roleName] = &celProgramCacheEntry{validatorHash: hash, program: p}
+
+	return p, nil
+}
+
+// forgetRoleCELProgram evicts the compiled validator program of the role from
+// the cache.
+func (b *exoscaleBackend) forgetRoleCELProgram(roleName string) {
+	b.celProgramsLock.Lock()
+	defer b.celProgramsLock.Unlock()
+
+	delete(b.celProgramsCache, roleName)
+}
+
+// buildCELProgram compiles the role validator expression, and records the
+// variables it references so that only those are resolved upon evaluation.
+func buildCELProgram(expression string) (*roleValidatorProgram, error) {
+	declarations := make([]*exprpb.Decl, 0, len(roleValidatorVarProviders))
+	for _, v := range roleValidatorVarProviders {
+		declarations = append(declarations, decls.NewVar(v.name, v.declType))
+	}
+
+	env, err := cel.NewEnv(
+		cel.Lib(celNetworkLib{}),
+		ext.Strings(),
+		cel.Declarations(declarations...),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -366,11 +717,30 @@ func buildCELProgram(expression string) (cel.Program, error) {
 		return nil, fmt.Errorf("bad expression: result type should be boolean")
 	}
 
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reference map of the checked expression records the declaration
+	// every identifier has been resolved to.
+	referenced := make(map[string]struct{})
+	for _, ref := range checked.ReferenceMap {
+		referenced[ref.Name] = struct{}{}
+	}
+
+	vars := make([]*roleValidatorVarProvider, 0)
+	for _, v := range roleValidatorVarProviders {
+		if _, ok := referenced[v.name]; ok {
+			vars = append(vars, v)
+		}
+	}
+
 	p, err := env.Program(ast,
 		cel.EvalOptions(cel.OptExhaustiveEval, cel.OptPartialEval))
 	if err != nil {
 		return nil, err
 	}
 
-	return p, nil
+	return &roleValidatorProgram{ast: ast, program: p, vars: vars}, nil
 }
@@ -0,0 +1,509 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+const (
+	secretIDStoragePathPrefix         = "secret-id/"
+	secretIDAccessorStoragePathPrefix = "secret-id-accessor/"
+
+	secretIDKeyAccessor       = "secret_id_accessor"
+	secretIDKeyCreated        = "creation_time"
+	secretIDKeyExpiration     = "expiration_time"
+	secretIDKeyInstancePoolID = "instance_pool_id"
+	secretIDKeyLabelSelector  = "label_selector"
+	secretIDKeyNumUses        = "num_uses"
+	secretIDKeySecretID       = "secret_id"
+	secretIDKeyTTL            = "ttl"
+)
+
+var (
+	pathRoleSecretIDHelpSyn  = "Generate and list role secret IDs"
+	pathRoleSecretIDHelpDesc = `
+This endpoint generates secret IDs for roles requiring them ("bind_secret_id"
+role parameter), and lists the accessors of the role secret IDs.
+
+Secret IDs must be bound to an Instance Pool and/or to an instance label
+selector (list of "key=value" pairs, all of which must match the labels of
+the instance logging in), and can be limited in number of uses and given a
+TTL. Expired secret IDs are periodically destroyed. The generated secret ID can be response-wrapped by Vault if requested by
+the client (e.g. using the "-wrap-ttl" CLI flag).
+`
+
+	pathRoleSecretIDAccessorHelpSyn  = "Manage role secret IDs"
+	pathRoleSecretIDAccessorHelpDesc = `
+This endpoint retrieves the properties of or destroys a role secret ID,
+identified by its accessor.
+`
+)
+
+type roleSecretID struct {
+	Accessor       string            `json:"accessor"`
+	InstancePoolID string            `json:"instance_pool_id,omitempty"`
+	LabelSelector  map[string]string `json:"label_selector,omitempty"`
+	NumUses        int               `json:"num_uses"`
+	CreationTime   time.Time         `json:"creation_time"`
+	ExpirationTime time.Time         `json:"expiration_time,omitempty"`
+}
+
+// checkInstance verifies that the instance matches the secret ID bindings.
+func (s *roleSecretID) checkInstance(instance *egoscale.Instance) error {
+	if s.InstancePoolID != "" &&
+		(instance.Manager == nil || instance.Manager.Type != "instance-pool" || instance.Manager.ID != s.InstancePoolID) {
+		return fmt.Errorf("%w: instance %s is not a member of the secret ID Instance Pool",
+			errAuthFailed,
+			*instance.ID)
+	}
+
+	for k, v := range s.LabelSelector {
+		if instance.Labels == nil || (*instance.Labels)[k] != v {
+			return fmt.Errorf("%w: instance %s doesn't match the secret ID label selector",
+				errAuthFailed,
+				*instance.ID)
+		}
+	}
+
+	return nil
+}
+
+func pathRoleSecretID(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex(roleKeyName) + "/secret-id/?$",
+		Fields: map[string]*framework.FieldSchema{
+			roleKeyName: {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			secretIDKeyInstancePoolID: {
+				Type:        framework.TypeString,
+				Description: "ID of the Instance Pool the instances using the secret ID must belong to",
+			},
+			secretIDKeyLabelSelector: {
+				Type:        framework.TypeKVPairs,
+				Description: "Labels the instances using the secret ID must bear (list of key=value pairs)",
+			},
+			secretIDKeyNumUses: {
+				Type:        framework.TypeInt,
+				Description: "Number of times the secret ID can be used to log in (0: unlimited)",
+			},
+			secretIDKeyTTL: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration after which the secret ID expires (0: never)",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.writeRoleSecretID},
+			logical.ListOperation:   &framework.PathOperation{Callback: b.listRoleSecretIDs},
+		},
+
+		HelpSynopsis:    pathRoleSecretIDHelpSyn,
+		HelpDescription: pathRoleSecretIDHelpDesc,
+	}
+}
+
+func pathRoleSecretIDAccessor(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex(roleKeyName) +
+			"/secret-id-accessor/" + framework.GenericNameRegex(secretIDKeyAccessor),
+		Fields: map[string]*framework.FieldSchema{
+			roleKeyName: {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			secretIDKeyAccessor: {
+				Type:        framework.TypeString,
+				Description: "Secret ID accessor",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.readRoleSecretID},
+			logical.DeleteOperation: &framework.PathOperation{Callback: b.deleteRoleSecretID},
+		},
+
+		HelpSynopsis:    pathRoleSecretIDAccessorHelpSyn,
+		HelpDescription: pathRoleSecretIDAccessorHelpDesc,
+	}
+}
+
+func secretIDStoragePath(roleName, secretID string) string {
+	hash := sha256.Sum256([]byte(secretID))
+	return secretIDStoragePathPrefix + roleName + "/" + hex.EncodeToString(hash[:])
+}
+
+func secretIDAccessorStoragePath(roleName, accessor string) string {
+	return secretIDAccessorStoragePathPrefix + roleName + "/" + accessor
+}
+
+func (b *exoscaleBackend) writeRoleSecretID(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName := data.Get(roleKeyName).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+	if !role.BindSecretID {
+		return logical.ErrorResponse("role %q doesn't require secret IDs (%q role parameter)",
+			roleName, roleKeyBindSecretID), nil
+	}
+
+	numUses := data.Get(secretIDKeyNumUses).(int)
+	if numUses < 0 {
+		return logical.ErrorResponse("%v: %s", errInvalidFieldValue, secretIDKeyNumUses), nil
+	}
+
+	instancePoolID := data.Get(secretIDKeyInstancePoolID).(string)
+	labelSelector := data.Get(secretIDKeyLabelSelector).(map[string]string)
+	// Unbound secret IDs could be used along with any instance passing the
+	// role validator.
+	if instancePoolID == "" && len(labelSelector) == 0 {
+		return logical.ErrorResponse("%v: %s or %s",
+			errMissingField,
+			secretIDKeyInstancePoolID,
+			secretIDKeyLabelSelector), nil
+	}
+
+	secretID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate secret ID: %w", err)
+	}
+	accessor, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate secret ID accessor: %w", err)
+	}
+
+	entry := roleSecretID{
+		Accessor:       accessor,
+		InstancePoolID: instancePoolID,
+		LabelSelector:  labelSelector,
+		NumUses:        numUses,
+		CreationTime:   time.Now().UTC(),
+	}
+	if ttl := data.Get(secretIDKeyTTL).(int); ttl > 0 {
+		entry.ExpirationTime = entry.CreationTime.Add(time.Duration(ttl) * time.Second)
+	}
+
+	storageEntry, err := logical.StorageEntryJSON(secretIDStoragePath(roleName, secretID), entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, storageEntry); err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Put(ctx, &logical.StorageEntry{
+		Key:   secretIDAccessorStoragePath(roleName, accessor),
+		Value: []byte(storageEntry.Key),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		secretIDKeySecretID: secretID,
+		secretIDKeyAccessor: accessor,
+		secretIDKeyTTL:      data.Get(secretIDKeyTTL).(int),
+	}}, nil
+}
+
+func (b *exoscaleBackend) listRoleSecretIDs(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName := data.Get(roleKeyName).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	accessors, err := req.Storage.List(ctx, secretIDAccessorStoragePathPrefix+roleName+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(accessors), nil
+}
+
+// roleSecretIDByAccessor returns the secret ID identified by accessor along
+// with its storage path.
+func (b *exoscaleBackend) roleSecretIDByAccessor(
+	ctx context.Context,
+	storage logical.Storage,
+	roleName, accessor string,
+) (*roleSecretID, string, error) {
+	index, err := storage.Get(ctx, secretIDAccessorStoragePath(roleName, accessor))
+	if err != nil || index == nil {
+		return nil, "", err
+	}
+
+	entry, err := storage.Get(ctx, string(index.Value))
+	if err != nil || entry == nil {
+		return nil, "", err
+	}
+
+	var secretID roleSecretID
+	if err := entry.DecodeJSON(&secretID); err != nil {
+		return nil, "", err
+	}
+
+	return &secretID, entry.Key, nil
+}
+
+func (b *exoscaleBackend) readRoleSecretID(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName := data.Get(roleKeyName).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	secretID, _, err := b.roleSecretIDByAccessor(
+		ctx,
+		req.Storage,
+		roleName,
+		data.Get(secretIDKeyAccessor).(string),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if secretID == nil {
+		return nil, nil
+	}
+
+	d := map[string]interface{}{
+		secretIDKeyAccessor:       secretID.Accessor,
+		secretIDKeyCreated:        secretID.CreationTime.Format(time.RFC3339),
+		secretIDKeyInstancePoolID: secretID.InstancePoolID,
+		secretIDKeyLabelSelector:  secretID.LabelSelector,
+		secretIDKeyNumUses:        secretID.NumUses,
+	}
+	if !secretID.ExpirationTime.IsZero() {
+		d[secretIDKeyExpiration] = secretID.ExpirationTime.Format(time.RFC3339)
+	}
+
+	return &logical.Response{Data: d}, nil
+}
+
+func (b *exoscaleBackend) deleteRoleSecretID(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName := data.Get(roleKeyName).(string)
+	accessor := data.Get(secretIDKeyAccessor).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+
+	b.secretIDLock.Lock()
+	defer b.secretIDLock.Unlock()
+
+	_, path, err := b.roleSecretIDByAccessor(ctx, req.Storage, roleName, accessor)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		if err := req.Storage.Delete(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, secretIDAccessorStoragePath(roleName, accessor)); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// deleteRoleSecretIDs destroys all the secret IDs of the specified role.
+func (b *exoscaleBackend) deleteRoleSecretIDs(ctx context.Context, storage logical.Storage, roleName string) error {
+	b.secretIDLock.Lock()
+	defer b.secretIDLock.Unlock()
+
+	for _, prefix := range []string{
+		secretIDStoragePathPrefix + roleName + "/",
+		secretIDAccessorStoragePathPrefix + roleName + "/",
+	} {
+		keys, err := storage.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := storage.Delete(ctx, prefix+k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// tidyRoleSecretIDs destroys the secret IDs which have expired, as well as the
+// accessors of secret IDs which no longer exist.
+func (b *exoscaleBackend) tidyRoleSecretIDs(ctx context.Context, storage logical.Storage) error {
+	b.secretIDLock.Lock()
+	defer b.secretIDLock.Unlock()
+
+	roles, err := storage.List(ctx, secretIDAccessorStoragePathPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to list secret ID accessors: %w", err)
+	}
+
+	for _, role := range roles {
+		accessors, err := storage.List(ctx, secretIDAccessorStoragePathPrefix+role)
+		if err != nil {
+			return fmt.Errorf("unable to list secret ID accessors: %w", err)
+		}
+
+		for _, accessor := range accessors {
+			accessorPath := secretIDAccessorStoragePathPrefix + role + accessor
+
+			index, err := storage.Get(ctx, accessorPath)
+			if err != nil {
+				return fmt.Errorf("unable to retrieve secret ID accessor: %w", err)
+			}
+			if index == nil {
+				continue
+			}
+
+			entry, err := storage.Get(ctx, string(index.Value))
+			if err != nil {
+				return fmt.Errorf("unable to retrieve secret ID: %w", err)
+			}
+			if entry != nil {
+				var secretID roleSecretID
+				if err := entry.DecodeJSON(&secretID); err != nil {
+					return fmt.Errorf("unable to decode secret ID: %w", err)
+				}
+				if secretID.ExpirationTime.IsZero() || time.Now().Before(secretID.ExpirationTime) {
+					continue
+				}
+
+				if err := storage.Delete(ctx, entry.Key); err != nil {
+					return fmt.Errorf("unable to delete secret ID: %w", err)
+				}
+			}
+
+			if err := storage.Delete(ctx, accessorPath); err != nil {
+				return fmt.Errorf("unable to delete secret ID accessor: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRoleSecretID verifies that the secret ID supplied by a client is valid
+// for the role and the instance logging in. If consume is true, its number of
+// remaining uses is decremented if limited.
+func (b *exoscaleBackend) checkRoleSecretID(
+	ctx context.Context,
+	storage logical.Storage,
+	roleName, secret string,
+	instance *egoscale.Instance,
+	consume bool,
+) error {
+	if secret == "" {
+		return fmt.Errorf("%w: %s", errMissingField, authLoginParamSecretID)
+	}
+
+	b.secretIDLock.Lock()
+	defer b.secretIDLock.Unlock()
+
+	path := secretIDStoragePath(roleName, secret)
+
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("%w: unable to retrieve secret ID: %v", errInternalError, err) // nolint:errorlint
+	}
+	if entry == nil {
+		return fmt.Errorf("%w: invalid secret ID", errAuthFailed)
+	}
+
+	var secretID roleSecretID
+	if err := entry.DecodeJSON(&secretID); err != nil {
+		return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	destroy := func() error {
+		if err := storage.Delete(ctx, path); err != nil {
+			return err
+		}
+		return storage.Delete(ctx, secretIDAccessorStoragePath(roleName, secretID.Accessor))
+	}
+
+	if !secretID.ExpirationTime.IsZero() && time.Now().After(secretID.ExpirationTime) {
+		if err := destroy(); err != nil {
+			return fmt.Errorf("%w: unable to destroy expired secret ID: %v", errInternalError, err) // nolint:errorlint
+		}
+		return fmt.Errorf("%w: secret ID %s has expired", errAuthFailed, secretID.Accessor)
+	}
+
+	if err := secretID.checkInstance(instance); err != nil {
+		return err
+	}
+
+	if !consume {
+		return nil
+	}
+
+	switch secretID.NumUses {
+	case 0:
+		// Unlimited uses
+
+	case 1:
+		if err := destroy(); err != nil {
+			return fmt.Errorf("%w: unable to destroy used secret ID: %v", errInternalError, err) // nolint:errorlint
+		}
+
+	default:
+		secretID.NumUses--
+		entry, err := logical.StorageEntryJSON(path, secretID)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			return fmt.Errorf("%w: unable to update secret ID: %v", errInternalError, err) // nolint:errorlint
+		}
+	}
+
+	return nil
+}
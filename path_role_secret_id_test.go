@@ -0,0 +1,283 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/mock"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+func (ts *backendTestSuite) TestPathRoleSecretID() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone, AppRoleMode: true})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator:    defaultRoleValidator,
+		BindSecretID: true,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Labels:          &testInstanceLabels,
+			Manager:         &egoscale.InstanceManager{ID: testInstancePoolID, Type: "instance-pool"},
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
+		Return(&egoscale.InstancePool{
+			ID:   &testInstancePoolID,
+			Name: &testInstancePoolName,
+		}, nil)
+
+	generate := func(data map[string]interface{}) *logical.Response {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   ts.storage,
+			Operation: logical.UpdateOperation,
+			Path:      roleStoragePathPrefix + testRoleName + "/secret-id",
+			Data:      data,
+		})
+		ts.Require().NoError(err)
+		ts.Require().False(res.IsError(), "%v", res.Error())
+		return res
+	}
+
+	login := func(secretID string) (*logical.Response, error) {
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data: map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRoleID:   testRoleName,
+				authLoginParamSecretID: secretID,
+			},
+		})
+	}
+
+	// Single-use secret ID bound to the instance pool and labels.
+	res := generate(map[string]interface{}{
+		secretIDKeyInstancePoolID: testInstancePoolID,
+		secretIDKeyLabelSelector:  []string{"k1=v1"},
+		secretIDKeyNumUses:        1,
+		secretIDKeyTTL:            3600,
+	})
+	secretID := res.Data[secretIDKeySecretID].(string)
+	accessor := res.Data[secretIDKeyAccessor].(string)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ListOperation,
+		Path:      roleStoragePathPrefix + testRoleName + "/secret-id",
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{accessor}, res.Data["keys"])
+
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ReadOperation,
+		Path:      fmt.Sprintf("%s%s/secret-id-accessor/%s", roleStoragePathPrefix, testRoleName, accessor),
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal(testInstancePoolID, res.Data[secretIDKeyInstancePoolID])
+	ts.Require().Equal(1, res.Data[secretIDKeyNumUses])
+	ts.Require().Contains(res.Data, secretIDKeyExpiration)
+
+	_, err = login("invalid")
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	res, err = login(secretID)
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	// The secret ID has been consumed.
+	_, err = login(secretID)
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.ListOperation,
+		Path:      roleStoragePathPrefix + testRoleName + "/secret-id",
+	})
+	ts.Require().NoError(err)
+	ts.Require().Empty(res.Data["keys"])
+
+	// Secret ID bound to labels the instance doesn't bear.
+	res = generate(map[string]interface{}{secretIDKeyLabelSelector: []string{"k1=nope"}})
+	_, err = login(res.Data[secretIDKeySecretID].(string))
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	// Secret ID bound to another instance pool.
+	res = generate(map[string]interface{}{secretIDKeyInstancePoolID: ts.randomID()})
+	_, err = login(res.Data[secretIDKeySecretID].(string))
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	// Unbound secret IDs are refused.
+	res, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName + "/secret-id",
+	})
+	ts.Require().NoError(err)
+	ts.Require().True(res.IsError())
+
+	// Unlimited secret ID, destroyed by its accessor.
+	res = generate(map[string]interface{}{secretIDKeyLabelSelector: []string{"k1=v1"}})
+	secretID = res.Data[secretIDKeySecretID].(string)
+	accessor = res.Data[secretIDKeyAccessor].(string)
+	for i := 0; i < 2; i++ {
+		res, err = login(secretID)
+		ts.Require().NoError(err)
+		ts.Require().NotNil(res.Auth)
+	}
+
+	_, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.DeleteOperation,
+		Path:      fmt.Sprintf("%s%s/secret-id-accessor/%s", roleStoragePathPrefix, testRoleName, accessor),
+	})
+	ts.Require().NoError(err)
+
+	_, err = login(secretID)
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+}
+
+func (ts *backendTestSuite) TestPathLoginSecretIDKeyBinding() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator:    defaultRoleValidator,
+		BindSecretID: true,
+		KeyBinding:   true,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			Labels:          &testInstanceLabels,
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	ts.Require().NoError(err)
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	ts.Require().NoError(err)
+
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName + "/secret-id",
+		Data: map[string]interface{}{
+			secretIDKeyLabelSelector: []string{"k1=v1"},
+			secretIDKeyNumUses:       1,
+		},
+	})
+	ts.Require().NoError(err)
+	secretID := res.Data[secretIDKeySecretID].(string)
+
+	login := func(key ed25519.PrivateKey) (*logical.Response, error) {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   ts.storage,
+			Operation: logical.UpdateOperation,
+			Path:      "nonce",
+			Data:      map[string]interface{}{nonceKeyInstance: testInstanceID},
+		})
+		ts.Require().NoError(err)
+		nonce := res.Data[nonceKeyNonce].(string)
+
+		return ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:    ts.storage,
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+			Data: map[string]interface{}{
+				authLoginParamInstance:  testInstanceID,
+				authLoginParamNonce:     nonce,
+				authLoginParamPublicKey: base64.StdEncoding.EncodeToString(publicKey),
+				authLoginParamRole:      testRoleName,
+				authLoginParamSecretID:  secretID,
+				authLoginParamSignature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(nonce))),
+			},
+		})
+	}
+
+	// Failed key binding: the secret ID is not consumed.
+	_, err = login(otherPrivateKey)
+	ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+
+	var stored roleSecretID
+	entry, err := ts.storage.Get(context.Background(), secretIDStoragePath(testRoleName, secretID))
+	ts.Require().NoError(err)
+	ts.Require().NotNil(entry)
+	ts.Require().NoError(entry.DecodeJSON(&stored))
+	ts.Require().Equal(1, stored.NumUses)
+
+	res, err = login(privateKey)
+	ts.Require().NoError(err)
+	ts.Require().NotNil(res.Auth)
+
+	entry, err = ts.storage.Get(context.Background(), secretIDStoragePath(testRoleName, secretID))
+	ts.Require().NoError(err)
+	ts.Require().Nil(entry)
+}
+
+func (ts *backendTestSuite) TestPathRoleSecretIDRoleNotFound() {
+	for _, req := range []*logical.Request{
+		{Operation: logical.ListOperation, Path: roleStoragePathPrefix + testRoleName + "/secret-id"},
+		{Operation: logical.ReadOperation, Path: roleStoragePathPrefix + testRoleName + "/secret-id-accessor/" + ts.randomID()},
+		{Operation: logical.DeleteOperation, Path: roleStoragePathPrefix + testRoleName + "/secret-id-accessor/" + ts.randomID()},
+	} {
+		req.Storage = ts.storage
+		res, err := ts.backend.HandleRequest(context.Background(), req)
+		ts.Require().NoError(err)
+		ts.Require().True(res.IsError(), "%s %s", req.Operation, req.Path)
+	}
+}
+
+func (ts *backendTestSuite) TestTidyRoleSecretIDs() {
+	ts.storeEntry(secretIDStoragePath(testRoleName, "expired"), roleSecretID{
+		Accessor:       "expired",
+		LabelSelector:  map[string]string{"k1": "v1"},
+		ExpirationTime: time.Now().Add(-time.Minute),
+	})
+	ts.storeEntry(secretIDStoragePath(testRoleName, "valid"), roleSecretID{
+		Accessor:      "valid",
+		LabelSelector: map[string]string{"k1": "v1"},
+	})
+	for accessor, path := range map[string]string{
+		"expired":  secretIDStoragePath(testRoleName, "expired"),
+		"valid":    secretIDStoragePath(testRoleName, "valid"),
+		"dangling": secretIDStoragePath(testRoleName, "dangling"),
+	} {
+		err := ts.storage.Put(context.Background(), &logical.StorageEntry{
+			Key:   secretIDAccessorStoragePath(testRoleName, accessor),
+			Value: []byte(path),
+		})
+		ts.Require().NoError(err)
+	}
+
+	err := ts.backend.(*exoscaleBackend).periodicFunc(context.Background(), &logical.Request{Storage: ts.storage})
+	ts.Require().NoError(err)
+
+	keys, err := ts.storage.List(context.Background(), secretIDAccessorStoragePathPrefix+testRoleName+"/")
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{"valid"}, keys)
+
+	entry, err := ts.storage.Get(context.Background(), secretIDStoragePath(testRoleName, "expired"))
+	ts.Require().NoError(err)
+	ts.Require().Nil(entry)
+}
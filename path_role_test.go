@@ -30,6 +30,29 @@ func (ts *backendTestSuite) TestPathRoleWrite() {
 				roleKeyValidator: "lolnope",
 			},
 		},
+		{
+			name: "fail_conflicting_validator_mode",
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().True(strings.Contains(res.Error().Error(), "mutually exclusive"))
+			},
+			reqData: map[string]interface{}{
+				roleKeyValidator:     testRole.Validator,
+				roleKeyValidatorMode: roleValidatorModePrivateIP,
+			},
+		},
+		{
+			name: "ok_validator_mode",
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, _ error) {
+				var actual backendRole
+				entry, err := ts.storage.Get(context.Background(), roleStoragePathPrefix+testRoleName)
+				ts.Require().NoError(err)
+				ts.Require().NoError(entry.DecodeJSON(&actual))
+				ts.Require().Equal(privateIPRoleValidator, actual.Validator)
+			},
+			reqData: map[string]interface{}{
+				roleKeyValidatorMode: roleValidatorModePrivateIP,
+			},
+		},
 		{
 			name: "ok",
 			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, _ error) {
@@ -63,6 +86,54 @@ func (ts *backendTestSuite) TestPathRoleWrite() {
 	}
 }
 
+func (ts *backendTestSuite) TestPathRoleUpdateBootstrapSecretLabel() {
+	customLabel := "custom-bootstrap-secret"
+
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator:             defaultRoleValidator,
+		BootstrapSecretSource: roleBootstrapSecretSourceLabel,
+		BootstrapSecretLabel:  customLabel,
+	})
+
+	// Updating another role parameter must not reset the bootstrap secret label.
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName,
+		Data:      map[string]interface{}{roleKeyValidatorMode: roleValidatorModePrivateIP},
+	})
+	ts.Require().NoError(err)
+	ts.Require().False(res != nil && res.IsError(), "%v", res)
+
+	var actual backendRole
+	entry, err := ts.storage.Get(context.Background(), roleStoragePathPrefix+testRoleName)
+	ts.Require().NoError(err)
+	ts.Require().NoError(entry.DecodeJSON(&actual))
+	ts.Require().Equal(privateIPRoleValidator, actual.Validator)
+	ts.Require().Equal(customLabel, actual.BootstrapSecretLabel)
+}
+
+func (ts *backendTestSuite) TestPathRoleUpdateValidator() {
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: privateIPRoleValidator})
+
+	// Updating another role parameter must not reset the validator.
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName,
+		Data:      map[string]interface{}{roleKeyKeyBinding: true},
+	})
+	ts.Require().NoError(err)
+	ts.Require().False(res != nil && res.IsError(), "%v", res)
+
+	var actual backendRole
+	entry, err := ts.storage.Get(context.Background(), roleStoragePathPrefix+testRoleName)
+	ts.Require().NoError(err)
+	ts.Require().NoError(entry.DecodeJSON(&actual))
+	ts.Require().Equal(privateIPRoleValidator, actual.Validator)
+	ts.Require().True(actual.KeyBinding)
+}
+
 func (ts *backendTestSuite) TestPathRoleRead() {
 	ts.storeEntry(roleStoragePathPrefix+testRoleName, testRole)
 
@@ -109,3 +180,32 @@ func (ts *backendTestSuite) TestPathRoleDelete() {
 	ts.Require().NoError(err)
 	ts.Require().Nil(res)
 }
+
+func (ts *backendTestSuite) TestRoleCELProgramCache() {
+	b := ts.backend.(*exoscaleBackend)
+
+	p1, err := b.roleCELProgram(testRoleName, &testRole)
+	ts.Require().NoError(err)
+	p2, err := b.roleCELProgram(testRoleName, &testRole)
+	ts.Require().NoError(err)
+	ts.Require().Same(p1, p2)
+
+	// A role validator modified behind the backend's back is compiled again.
+	p3, err := b.roleCELProgram(testRoleName, &backendRole{Validator: privateIPRoleValidator})
+	ts.Require().NoError(err)
+	ts.Require().NotSame(p1, p3)
+
+	_, err = ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.CreateOperation,
+		Path:      roleStoragePathPrefix + testRoleName,
+		Data:      map[string]interface{}{roleKeyValidator: testRole.Validator},
+	})
+	ts.Require().NoError(err)
+	ts.Require().NotContains(b.celProgramsCache, testRoleName)
+
+	_, err = b.roleCELProgram(testRoleName, &testRole)
+	ts.Require().NoError(err)
+	b.invalidate(context.Background(), roleStoragePathPrefix+testRoleName)
+	ts.Require().NotContains(b.celProgramsCache, testRoleName)
+}
@@ -0,0 +1,227 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleTestKeyClientIP    = "client_ip"
+	roleTestKeyInstance    = "instance"
+	roleTestKeyNow         = "now"
+	roleTestKeyResult      = "result"
+	roleTestKeySAName      = "sa_name"
+	roleTestKeySANamespace = "sa_namespace"
+	roleTestKeyTrace       = "trace"
+	roleTestKeyVars        = "vars"
+	roleTestKeyZone        = "zone"
+)
+
+var (
+	pathRoleTestHelpSyn  = "Test a role validator against a Compute instance"
+	pathRoleTestHelpDesc = `
+This endpoint evaluates the validator expression of a role against a Compute
+instance without issuing any token, to help debugging validator expressions.
+
+The client IP address and the current time exposed to the validator can be
+simulated using the "client_ip" and "now" (RFC 3339 timestamp) parameters; by
+default, the client IP address is one of the instance Private Network IP
+addresses for roles using the "private-ip" validator mode, and the instance
+public IP address otherwise.
+
+Roles bound to an SKS cluster require the Kubernetes service account the
+login would be performed with to be specified using the "sa_namespace" and
+"sa_name" parameters; the service account token itself is not verified.
+
+The response contains the values of the variables referenced by the
+validator expression, the result of the expression, and the value of every
+subexpression evaluated.
+`
+)
+
+func pathRoleTest(b *exoscaleBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex(roleKeyName) + "/test/?$",
+		Fields: map[string]*framework.FieldSchema{
+			roleKeyName: {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			roleTestKeyClientIP: {
+				Type:        framework.TypeString,
+				Description: "Simulated client IP address (default: instance private IP address for private-ip validator mode roles, public IP address otherwise)",
+			},
+			roleTestKeyInstance: {
+				Type:        framework.TypeString,
+				Description: "ID of the Compute instance to test the role validator against",
+				Required:    true,
+			},
+			roleTestKeyNow: {
+				Type:        framework.TypeString,
+				Description: "Simulated current time, as an RFC 3339 timestamp (default: current time)",
+			},
+			roleTestKeySAName: {
+				Type:        framework.TypeString,
+				Description: "Simulated Kubernetes service account name (SKS roles only)",
+			},
+			roleTestKeySANamespace: {
+				Type:        framework.TypeString,
+				Description: "Simulated Kubernetes service account namespace (SKS roles only)",
+			},
+			roleTestKeyZone: {
+				Type:        framework.TypeString,
+				Description: "Zone of the Compute instance (default: all configured zones)",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.testRole},
+		},
+
+		HelpSynopsis:    pathRoleTestHelpSyn,
+		HelpDescription: pathRoleTestHelpDesc,
+	}
+}
+
+func (b *exoscaleBackend) testRole(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roleName := data.Get(roleKeyName).(string)
+
+	role, err := b.roleConfig(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", roleName), nil
+	}
+	if role.AuthType == roleAuthTypeIAM {
+		return logical.ErrorResponse("role %q doesn't support instance authentication", roleName), nil
+	}
+
+	instanceID := data.Get(roleTestKeyInstance).(string)
+	if instanceID == "" {
+		return logical.ErrorResponse("%v: %s", errMissingField, roleTestKeyInstance), nil
+	}
+
+	now := time.Now()
+	if v := data.Get(roleTestKeyNow).(string); v != "" {
+		if now, err = time.Parse(time.RFC3339, v); err != nil {
+			return logical.ErrorResponse("%v: %s: %s", errInvalidFieldValue, roleTestKeyNow, err), nil
+		}
+	}
+
+	clientIP := data.Get(roleTestKeyClientIP).(string)
+	if clientIP != "" {
+		if net.ParseIP(clientIP) == nil {
+			return logical.ErrorResponse("%v: %s", errInvalidFieldValue, roleTestKeyClientIP), nil
+		}
+		clientIP = canonicalIP(clientIP)
+	}
+
+	var serviceAccount *sksServiceAccount
+	saName, saNamespace := data.Get(roleTestKeySAName).(string), data.Get(roleTestKeySANamespace).(string)
+	switch {
+	case role.SKSClusterID == "" && (saName != "" || saNamespace != ""):
+		return logical.ErrorResponse("%v: %s/%s: role %q is not bound to an SKS cluster",
+			errInvalidFieldValue,
+			roleTestKeySANamespace,
+			roleTestKeySAName,
+			roleName), nil
+
+	case role.SKSClusterID != "" && saName == "":
+		return logical.ErrorResponse("%v: %s", errMissingField, roleTestKeySAName), nil
+
+	case role.SKSClusterID != "" && saNamespace == "":
+		return logical.ErrorResponse("%v: %s", errMissingField, roleTestKeySANamespace), nil
+
+	case role.SKSClusterID != "":
+		serviceAccount = &sksServiceAccount{Namespace: saNamespace, Name: saName}
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("backend is not configured"), nil
+	}
+
+	accounts, err := b.accounts(ctx, req.Storage, config, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if zone := data.Get(roleTestKeyZone).(string); zone != "" {
+		if accounts = accountsInZone(accounts, zone); len(accounts) == 0 {
+			return logical.ErrorResponse("%v: %s: zone %q is not configured",
+				errInvalidFieldValue,
+				roleTestKeyZone,
+				zone), nil
+		}
+	}
+
+	account, instance, err := b.getInstance(ctx, accounts, instanceID)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	p, err := b.roleCELProgram(roleName, role)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile role validator: %w", err)
+	}
+
+	env := newRoleValidatorEnv(
+		account.withEndpoint(ctx, account.zones[0]),
+		account.exo,
+		clientIP,
+		instance,
+		serviceAccount,
+		config.apiConcurrency(),
+	)
+	env.now = now
+	defer env.close()
+
+	// Roles using the private-ip validator mode are tested by default against
+	// the instance IP address on the first of its Private Networks by name.
+	if env.clientIP == "" && role.Validator == privateIPRoleValidator {
+		privateIPs, err := env.getPrivateIPs()
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		networks := make([]string, 0, len(privateIPs))
+		for network := range privateIPs {
+			networks = append(networks, network)
+		}
+		if len(networks) > 0 {
+			sort.Strings(networks)
+			env.clientIP = privateIPs[networks[0]]
+		}
+	}
+	if env.clientIP == "" {
+		if env.clientIP = ipString(instance.PublicIPAddress); env.clientIP == "" {
+			env.clientIP = ipString(instance.IPv6Address)
+		}
+	}
+
+	result, err := p.eval(env)
+	if err != nil {
+		return logical.ErrorResponse("unable to evaluate role validator: %s", err), nil
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		roleTestKeyResult: result.value,
+		roleTestKeyTrace:  p.trace(result.state),
+		roleTestKeyVars:   result.vars,
+	}}, nil
+}
@@ -0,0 +1,178 @@
+package exoscale
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/mock"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+func (ts *backendTestSuite) TestPathRoleTest() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator: defaultRoleValidator + ` && ` +
+			roleValidatorVarInstanceSecurityGroupNames + `.exists(n, n == "` + testInstanceSecurityGroupName + `")`,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:        &testInstanceCreated,
+			ID:               &testInstanceID,
+			Name:             &testInstanceName,
+			PublicIPAddress:  &testInstanceIPAddress,
+			SecurityGroupIDs: &[]string{testInstanceSecurityGroupID},
+			State:            &testInstanceState,
+			Zone:             &testZone,
+		}, nil)
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetSecurityGroup", mock.Anything, testZone, testInstanceSecurityGroupID).
+		Return(&egoscale.SecurityGroup{
+			ID:   &testInstanceSecurityGroupID,
+			Name: &testInstanceSecurityGroupName,
+		}, nil)
+
+	test := func(data map[string]interface{}) *logical.Response {
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   ts.storage,
+			Operation: logical.UpdateOperation,
+			Path:      roleStoragePathPrefix + testRoleName + "/test",
+			Data:      data,
+		})
+		ts.Require().NoError(err)
+		ts.Require().Nil(res.Auth)
+		return res
+	}
+
+	traceValue := func(res *logical.Response, expression string) interface{} {
+		for _, entry := range res.Data[roleTestKeyTrace].([]map[string]interface{}) {
+			if entry["expression"] == expression {
+				return entry["value"]
+			}
+		}
+		ts.FailNow("expression not found in trace", expression)
+		return nil
+	}
+
+	// The client IP address defaults to the instance public IP address.
+	res := test(map[string]interface{}{roleTestKeyInstance: testInstanceID})
+	ts.Require().False(res.IsError(), "%v", res.Error())
+	ts.Require().Equal(true, res.Data[roleTestKeyResult])
+	ts.Require().Equal(testInstanceIPAddress.String(), res.Data[roleTestKeyVars].(map[string]interface{})[roleValidatorVarClientIP])
+	ts.Require().Equal([]string{testInstanceSecurityGroupName},
+		res.Data[roleTestKeyVars].(map[string]interface{})[roleValidatorVarInstanceSecurityGroupNames])
+	ts.Require().NotContains(res.Data[roleTestKeyVars], roleValidatorVarInstanceName)
+	ts.Require().Equal(true, traceValue(res,
+		roleValidatorVarInstanceSecurityGroupNames+`.exists(n, n == "`+testInstanceSecurityGroupName+`")`))
+
+	res = test(map[string]interface{}{
+		roleTestKeyClientIP: testOtherInstanceIPAddress.String(),
+		roleTestKeyInstance: testInstanceID,
+		roleTestKeyNow:      "2021-06-01T12:00:00Z",
+	})
+	ts.Require().False(res.IsError(), "%v", res.Error())
+	ts.Require().Equal(false, res.Data[roleTestKeyResult])
+	ts.Require().Equal(false, traceValue(res, roleValidatorVarClientIP+" == "+roleValidatorVarInstancePublicIP))
+	ts.Require().Equal(testOtherInstanceIPAddress.String(), traceValue(res, roleValidatorVarClientIP))
+
+	res = test(map[string]interface{}{
+		roleTestKeyInstance: testInstanceID,
+		roleTestKeyNow:      "yesterday",
+	})
+	ts.Require().True(res.IsError())
+
+	res = test(map[string]interface{}{
+		roleTestKeyInstance: testOtherInstanceID,
+		roleTestKeyZone:     "de-fra-1",
+	})
+	ts.Require().True(res.IsError())
+}
+
+func (ts *backendTestSuite) TestPathRoleTestPrivateIP() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{Validator: privateIPRoleValidator})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:         &testInstanceCreated,
+			ID:                &testInstanceID,
+			PrivateNetworkIDs: &[]string{testInstancePrivateNetworkID},
+			PublicIPAddress:   &testInstanceIPAddress,
+			State:             &testInstanceState,
+			Zone:              &testZone,
+		}, nil)
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetPrivateNetwork", mock.Anything, testZone, testInstancePrivateNetworkID).
+		Return(&egoscale.PrivateNetwork{
+			ID:   &testInstancePrivateNetworkID,
+			Name: &testInstancePrivateNetworkName,
+			Leases: []*egoscale.PrivateNetworkLease{{
+				InstanceID: &testInstanceID,
+				IPAddress:  &testInstancePrivateIPAddress,
+			}},
+		}, nil)
+
+	// The client IP address defaults to the instance private IP address.
+	res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   ts.storage,
+		Operation: logical.UpdateOperation,
+		Path:      roleStoragePathPrefix + testRoleName + "/test",
+		Data:      map[string]interface{}{roleTestKeyInstance: testInstanceID},
+	})
+	ts.Require().NoError(err)
+	ts.Require().False(res.IsError(), "%v", res.Error())
+	ts.Require().Equal(true, res.Data[roleTestKeyResult])
+	ts.Require().Equal(testInstancePrivateIPAddress.String(),
+		res.Data[roleTestKeyVars].(map[string]interface{})[roleValidatorVarClientIP])
+}
+
+func (ts *backendTestSuite) TestPathRoleTestSKS() {
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator:    `sa_namespace == "` + testSKSServiceAccountNS + `" && sa_name == "` + testSKSServiceAccountSA + `"`,
+		SKSClusterID: testSKSClusterID,
+	})
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt:       &testInstanceCreated,
+			ID:              &testInstanceID,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	test := func(data map[string]interface{}) *logical.Response {
+		data[roleTestKeyInstance] = testInstanceID
+		res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   ts.storage,
+			Operation: logical.UpdateOperation,
+			Path:      roleStoragePathPrefix + testRoleName + "/test",
+			Data:      data,
+		})
+		ts.Require().NoError(err)
+		return res
+	}
+
+	res := test(map[string]interface{}{
+		roleTestKeySAName:      testSKSServiceAccountSA,
+		roleTestKeySANamespace: testSKSServiceAccountNS,
+	})
+	ts.Require().False(res.IsError(), "%v", res.Error())
+	ts.Require().Equal(true, res.Data[roleTestKeyResult])
+
+	res = test(map[string]interface{}{
+		roleTestKeySAName:      testSKSServiceAccountSA,
+		roleTestKeySANamespace: "kube-system",
+	})
+	ts.Require().False(res.IsError(), "%v", res.Error())
+	ts.Require().Equal(false, res.Data[roleTestKeyResult])
+
+	// The service account is required for SKS roles.
+	res = test(map[string]interface{}{roleTestKeySAName: testSKSServiceAccountSA})
+	ts.Require().True(res.IsError())
+}
@@ -0,0 +1,1257 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/parser"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+	exoapi "github.com/exoscale/egoscale/v2/api"
+)
+
+// roleValidatorVarProvider represents a variable available to role validator
+// expressions, along with the function resolving its value for the instance
+// being authenticated.
+type roleValidatorVarProvider struct {
+	name        string
+	description string
+	declType    *exprpb.Type
+	resolve     func(env *roleValidatorEnv) (interface{}, error)
+}
+
+// roleValidatorVarProviders is the registry of the variables available to
+// role validator expressions, sorted by name.
+var roleValidatorVarProviders = []*roleValidatorVarProvider{
+	{
+		name:        roleValidatorVarClientIP,
+		description: "IP address of the Vault client (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return env.clientIP, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceAAGIDs,
+		description: "list of Anti-Affinity Group IDs the instance belongs to (list of strings)",
+		declType:    decls.NewListType(decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			antiAffinityGroups, err := env.getAntiAffinityGroups()
+			if err != nil {
+				return nil, err
+			}
+
+			ids := make([]string, 0, len(antiAffinityGroups))
+			for _, antiAffinityGroup := range antiAffinityGroups {
+				ids = append(ids, *antiAffinityGroup.ID)
+			}
+
+			return ids, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceAAGNames,
+		description: "list of Anti-Affinity Group names the instance belongs to (list of strings)",
+		declType:    decls.NewListType(decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			antiAffinityGroups, err := env.getAntiAffinityGroups()
+			if err != nil {
+				return nil, err
+			}
+
+			names := make([]string, 0, len(antiAffinityGroups))
+			for _, antiAffinityGroup := range antiAffinityGroups {
+				names = append(names, *antiAffinityGroup.Name)
+			}
+
+			return names, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceCreated,
+		description: "creation date of the instance (timestamp)",
+		declType:    decls.Timestamp,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return *env.instance.CreatedAt, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceDeployTargetID,
+		description: "ID of the Deploy Target the instance runs on, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringValue(env.instance.DeployTargetID), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceDeployTargetName,
+		description: "name of the Deploy Target the instance runs on, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			deployTarget, err := env.getDeployTarget()
+			if err != nil || deployTarget == nil {
+				return "", err
+			}
+
+			return stringValue(deployTarget.Name), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceElasticIPs,
+		description: "list of Elastic IP addresses attached to the instance (list of strings)",
+		declType:    decls.NewListType(decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return env.getElasticIPs()
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceID,
+		description: "ID of the instance (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return *env.instance.ID, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceLabels,
+		description: "map of instance labels (map[string]string)",
+		declType:    decls.NewMapType(decls.String, decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringMapValue(env.instance.Labels), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManager,
+		description: "type of the instance manager, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			if env.instance.Manager == nil {
+				return "", nil
+			}
+
+			return env.instance.Manager.Type, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerDescription,
+		description: "description of the Instance Pool managing the instance, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil || instancePool == nil {
+				return "", err
+			}
+
+			return stringValue(instancePool.Description), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerID,
+		description: "ID of the instance manager, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			if env.instance.Manager == nil {
+				return "", nil
+			}
+
+			return env.instance.Manager.ID, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerInstType,
+		description: "instance type ID of the Instance Pool managing the instance, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil || instancePool == nil {
+				return "", err
+			}
+
+			return stringValue(instancePool.InstanceTypeID), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerLabels,
+		description: "map of labels of the Instance Pool managing the instance, if any (map[string]string)",
+		declType:    decls.NewMapType(decls.String, decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil {
+				return nil, err
+			}
+			if instancePool == nil {
+				return map[string]string{}, nil
+			}
+
+			return stringMapValue(instancePool.Labels), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerName,
+		description: "name of the instance manager, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			if env.instance.Manager == nil {
+				return "", nil
+			}
+
+			switch env.instance.Manager.Type {
+			case "instance-pool":
+				instancePool, err := env.getInstancePool()
+				if err != nil {
+					return nil, err
+				}
+				return *instancePool.Name, nil
+
+			case "sks-nodepool":
+				sksNodepool, err := env.getSKSNodepool()
+				if err != nil || sksNodepool == nil {
+					return "", err
+				}
+				return *sksNodepool.nodepool.Name, nil
+
+			default:
+				return "", nil
+			}
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerSize,
+		description: "size of the Instance Pool managing the instance, if any (int)",
+		declType:    decls.Int,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil || instancePool == nil || instancePool.Size == nil {
+				return int64(0), err
+			}
+
+			return *instancePool.Size, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerState,
+		description: "state of the Instance Pool managing the instance, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil || instancePool == nil {
+				return "", err
+			}
+
+			return stringValue(instancePool.State), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceManagerTemplateID,
+		description: "template ID of the Instance Pool managing the instance, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instancePool, err := env.getInstancePool()
+			if err != nil || instancePool == nil {
+				return "", err
+			}
+
+			return stringValue(instancePool.TemplateID), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceName,
+		description: "name of the instance (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringValue(env.instance.Name), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstancePrivateIPs,
+		description: "map of Private Network names to instance IP addresses (map[string]string)",
+		declType:    decls.NewMapType(decls.String, decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return env.getPrivateIPs()
+		},
+	},
+	{
+		name:        roleValidatorVarInstancePublicIP,
+		description: "public IPv4 address of the instance (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return ipString(env.instance.PublicIPAddress), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstancePublicIPv6,
+		description: "public IPv6 address of the instance, if enabled (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return ipString(env.instance.IPv6Address), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSecurityGroupIDs,
+		description: "list of Security Group IDs the instance belongs to (list of strings)",
+		declType:    decls.NewListType(decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			securityGroups, err := env.getSecurityGroups()
+			if err != nil {
+				return nil, err
+			}
+
+			ids := make([]string, 0, len(securityGroups))
+			for _, securityGroup := range securityGroups {
+				ids = append(ids, *securityGroup.ID)
+			}
+
+			return ids, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSecurityGroupNames,
+		description: "list of Security Group names the instance belongs to (list of strings)",
+		declType:    decls.NewListType(decls.String),
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			securityGroups, err := env.getSecurityGroups()
+			if err != nil {
+				return nil, err
+			}
+
+			names := make([]string, 0, len(securityGroups))
+			for _, securityGroup := range securityGroups {
+				names = append(names, *securityGroup.Name)
+			}
+
+			return names, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSKSClusterID,
+		description: "ID of the SKS cluster the instance is a node of, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			sksNodepool, err := env.getSKSNodepool()
+			if err != nil || sksNodepool == nil {
+				return "", err
+			}
+
+			return *sksNodepool.cluster.ID, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSKSClusterName,
+		description: "name of the SKS cluster the instance is a node of, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			sksNodepool, err := env.getSKSNodepool()
+			if err != nil || sksNodepool == nil {
+				return "", err
+			}
+
+			return *sksNodepool.cluster.Name, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSKSNodepoolName,
+		description: "name of the SKS Nodepool the instance belongs to, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			sksNodepool, err := env.getSKSNodepool()
+			if err != nil || sksNodepool == nil {
+				return "", err
+			}
+
+			return *sksNodepool.nodepool.Name, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSSHKeyFingerprint,
+		description: "fingerprint of the SSH key the instance was created with, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			sshKey, err := env.getSSHKey()
+			if err != nil || sshKey == nil {
+				return "", err
+			}
+
+			return stringValue(sshKey.Fingerprint), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceSSHKeyName,
+		description: "name of the SSH key the instance was created with, if any (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringValue(env.instance.SSHKey), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceState,
+		description: "state of the instance, e.g. \"running\" (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringValue(env.instance.State), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTemplateID,
+		description: "ID of the instance template (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return stringValue(env.instance.TemplateID), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTemplateName,
+		description: "name of the instance template (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			template, err := env.getTemplate()
+			if err != nil || template == nil {
+				return "", err
+			}
+
+			return stringValue(template.Name), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTemplateVisibility,
+		description: "visibility of the instance template, \"public\" or \"private\" (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			template, err := env.getTemplate()
+			if err != nil || template == nil {
+				return "", err
+			}
+
+			return stringValue(template.Visibility), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTypeCPUs,
+		description: "number of CPUs of the instance type (int)",
+		declType:    decls.Int,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instanceType, err := env.getInstanceType()
+			if err != nil || instanceType == nil || instanceType.CPUs == nil {
+				return int64(0), err
+			}
+
+			return *instanceType.CPUs, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTypeFamily,
+		description: "family of the instance type, e.g. \"standard\" or \"gpu2\" (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instanceType, err := env.getInstanceType()
+			if err != nil || instanceType == nil {
+				return "", err
+			}
+
+			return stringValue(instanceType.Family), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTypeMemory,
+		description: "memory of the instance type, in bytes (int)",
+		declType:    decls.Int,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instanceType, err := env.getInstanceType()
+			if err != nil || instanceType == nil || instanceType.Memory == nil {
+				return int64(0), err
+			}
+
+			return *instanceType.Memory, nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceTypeSize,
+		description: "size of the instance type, e.g. \"medium\" (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			instanceType, err := env.getInstanceType()
+			if err != nil || instanceType == nil {
+				return "", err
+			}
+
+			return stringValue(instanceType.Size), nil
+		},
+	},
+	{
+		name:        roleValidatorVarInstanceZone,
+		description: "name of the instance's zone (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return *env.instance.Zone, nil
+		},
+	},
+	{
+		name:        roleValidatorVarNow,
+		description: "current timestamp (timestamp)",
+		declType:    decls.Timestamp,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			return env.now, nil
+		},
+	},
+	{
+		name:        roleValidatorVarSAName,
+		description: "name of the Kubernetes service account, for SKS roles (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			if env.serviceAccount == nil {
+				return "", nil
+			}
+
+			return env.serviceAccount.Name, nil
+		},
+	},
+	{
+		name:        roleValidatorVarSANamespace,
+		description: "namespace of the Kubernetes service account, for SKS roles (string)",
+		declType:    decls.String,
+		resolve: func(env *roleValidatorEnv) (interface{}, error) {
+			if env.serviceAccount == nil {
+				return "", nil
+			}
+
+			return env.serviceAccount.Namespace, nil
+		},
+	},
+}
+
+// roleValidatorProgram represents a compiled role validator expression, along
+// with the variables it references.
+type roleValidatorProgram struct {
+	ast     *cel.Ast
+	program cel.Program
+	vars    []*roleValidatorVarProvider
+}
+
+// roleValidatorResult represents the result of the evaluation of a role
+// validator expression.
+type roleValidatorResult struct {
+	// vars are the values of the variables referenced by the expression.
+	vars map[string]interface{}
+
+	// value is the boolean result of the expression.
+	value bool
+
+	// state records the value of every evaluated subexpression.
+	state interpreter.EvalState
+}
+
+// eval evaluates the role validator expression in the specified environment,
+// only resolving the variables referenced by the expression. Variables are
+// resolved concurrently.
+func (p *roleValidatorProgram) eval(env *roleValidatorEnv) (*roleValidatorResult, error) {
+	values := make([]interface{}, len(p.vars))
+	err := env.parallel(len(p.vars), func(i int) error {
+		value, err := p.vars[i].resolve(env)
+		values[i] = value
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	evalContext := make(map[string]interface{}, len(p.vars))
+	for i, v := range p.vars {
+		evalContext[v.name] = values[i]
+	}
+
+	out, details, err := p.program.Eval(evalContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleValidatorResult{
+		vars:  evalContext,
+		value: out.Value().(bool),
+		state: details.State(),
+	}, nil
+}
+
+// roleValidationError is returned when a role validator expression evaluates
+// to false. It records the top-level conjuncts of the expression which didn't
+// hold: those are logged and audited, but not disclosed to the client.
+type roleValidationError struct {
+	denials []string
+}
+
+func (e *roleValidationError) Error() string {
+	return errAuthFailed.Error() + ": role validation failed"
+}
+
+func (e *roleValidationError) Unwrap() error {
+	return errAuthFailed
+}
+
+// roleValidatorEnv represents the environment role validator variables are
+// resolved in. The Exoscale resources related to the instance are retrieved
+// on first use only, and at most once. The number of concurrent Exoscale API
+// calls is bounded, and the first lookup failure cancels the pending ones.
+type roleValidatorEnv struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	exo            exoscaleClient
+	clientIP       string
+	instance       *egoscale.Instance
+	serviceAccount *sksServiceAccount
+	now            time.Time
+
+	calls   chan struct{}
+	errOnce sync.Once
+	err     error
+
+	antiAffinityGroups lazyValue
+	deployTarget       lazyValue
+	elasticIPs         lazyValue
+	instancePool       lazyValue
+	instanceType       lazyValue
+	privateIPs         lazyValue
+	securityGroups     lazyValue
+	sksNodepool        lazyValue
+	sshKey             lazyValue
+	template           lazyValue
+}
+
+// newRoleValidatorEnv returns a role validator environment performing at most
+// concurrency Exoscale API calls at a time. The environment must be closed
+// once the role validator has been evaluated.
+func newRoleValidatorEnv(
+	ctx context.Context,
+	exo exoscaleClient,
+	clientIP string,
+	instance *egoscale.Instance,
+	serviceAccount *sksServiceAccount,
+	concurrency int,
+) *roleValidatorEnv {
+	env := &roleValidatorEnv{
+		exo:            exo,
+		clientIP:       clientIP,
+		instance:       instance,
+		serviceAccount: serviceAccount,
+		now:            time.Now(),
+		calls:          make(chan struct{}, concurrency),
+	}
+	env.ctx, env.cancel = context.WithCancel(ctx)
+
+	return env
+}
+
+// close releases the resources associated with the environment.
+func (env *roleValidatorEnv) close() {
+	env.cancel()
+}
+
+// call performs an Exoscale API call, waiting for the number of calls in
+// flight to drop below the concurrency limit.
+func (env *roleValidatorEnv) call(fn func() error) error {
+	select {
+	case env.calls <- struct{}{}:
+	case <-env.ctx.Done():
+		return env.ctx.Err()
+	}
+	defer func() { <-env.calls }()
+
+	return fn()
+}
+
+// parallel calls fn concurrently for every index in [0, n). Upon failure, the
+// pending Exoscale API calls are cancelled, and the error returned is the one
+// which caused the cancellation rather than the resulting cancellation errors.
+func (env *roleValidatorEnv) parallel(n int, fn func(i int) error) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, n)
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(i); err != nil {
+				errs[i] = env.fail(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fail records the first lookup error of the environment and cancels the
+// pending Exoscale API calls, returning the recorded error.
+func (env *roleValidatorEnv) fail(err error) error {
+	env.errOnce.Do(func() {
+		env.err = err
+		env.cancel()
+	})
+
+	return env.err
+}
+
+// roleValidatorSKSNodepool represents the SKS Nodepool an instance belongs
+// to, along with its cluster.
+type roleValidatorSKSNodepool struct {
+	cluster  *egoscale.SKSCluster
+	nodepool *egoscale.SKSNodepool
+}
+
+// lazyValue memoizes the result of the function computing a value.
+type lazyValue struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+func (v *lazyValue) get(fn func() (interface{}, error)) (interface{}, error) {
+	v.once.Do(func() { v.value, v.err = fn() })
+	return v.value, v.err
+}
+
+// getAntiAffinityGroups returns the Anti-Affinity Groups the instance belongs
+// to.
+func (env *roleValidatorEnv) getAntiAffinityGroups() ([]*egoscale.AntiAffinityGroup, error) {
+	v, err := env.antiAffinityGroups.get(func() (interface{}, error) {
+		if env.instance.AntiAffinityGroupIDs == nil {
+			return []*egoscale.AntiAffinityGroup{}, nil
+		}
+
+		ids := *env.instance.AntiAffinityGroupIDs
+		antiAffinityGroups := make([]*egoscale.AntiAffinityGroup, len(ids))
+		err := env.parallel(len(ids), func(i int) error {
+			return env.call(func() (err error) {
+				antiAffinityGroups[i], err = env.exo.GetAntiAffinityGroup(env.ctx, *env.instance.Zone, ids[i])
+				if err != nil {
+					return fmt.Errorf("unable to retrieve Anti-Affinity Group %q: %w", ids[i], err)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return antiAffinityGroups, nil
+	})
+	antiAffinityGroups, _ := v.([]*egoscale.AntiAffinityGroup)
+
+	return antiAffinityGroups, err
+}
+
+// getDeployTarget returns the Deploy Target the instance runs on, or nil if
+// the instance doesn't run on a Deploy Target.
+func (env *roleValidatorEnv) getDeployTarget() (*egoscale.DeployTarget, error) {
+	v, err := env.deployTarget.get(func() (interface{}, error) {
+		if env.instance.DeployTargetID == nil {
+			return nil, nil
+		}
+
+		var deployTarget *egoscale.DeployTarget
+		err := env.call(func() (err error) {
+			deployTarget, err = env.exo.GetDeployTarget(env.ctx, *env.instance.Zone, *env.instance.DeployTargetID)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve Deploy Target %q: %w", *env.instance.DeployTargetID, err)
+		}
+
+		return deployTarget, nil
+	})
+	deployTarget, _ := v.(*egoscale.DeployTarget)
+
+	return deployTarget, err
+}
+
+// getElasticIPs returns the addresses of the Elastic IPs attached to the
+// instance.
+func (env *roleValidatorEnv) getElasticIPs() ([]string, error) {
+	v, err := env.elasticIPs.get(func() (interface{}, error) {
+		if env.instance.ElasticIPIDs == nil {
+			return []string{}, nil
+		}
+
+		ids := *env.instance.ElasticIPIDs
+		elasticIPs := make([]string, len(ids))
+		err := env.parallel(len(ids), func(i int) error {
+			return env.call(func() error {
+				elasticIP, err := env.exo.GetElasticIP(env.ctx, *env.instance.Zone, ids[i])
+				if err != nil {
+					return fmt.Errorf("unable to retrieve Elastic IP %q: %w", ids[i], err)
+				}
+				elasticIPs[i] = ipString(elasticIP.IPAddress)
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return elasticIPs, nil
+	})
+	elasticIPs, _ := v.([]string)
+
+	return elasticIPs, err
+}
+
+// getInstancePool returns the Instance Pool managing the instance, or nil if
+// the instance is not managed by an Instance Pool.
+func (env *roleValidatorEnv) getInstancePool() (*egoscale.InstancePool, error) {
+	v, err := env.instancePool.get(func() (interface{}, error) {
+		if env.instance.Manager == nil || env.instance.Manager.Type != "instance-pool" {
+			return nil, nil
+		}
+
+		var instancePool *egoscale.InstancePool
+		err := env.call(func() (err error) {
+			instancePool, err = env.exo.GetInstancePool(env.ctx, *env.instance.Zone, env.instance.Manager.ID)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve Instance Pool %q: %w", env.instance.Manager.ID, err)
+		}
+
+		return instancePool, nil
+	})
+	instancePool, _ := v.(*egoscale.InstancePool)
+
+	return instancePool, err
+}
+
+// getInstanceType returns the type of the instance.
+func (env *roleValidatorEnv) getInstanceType() (*egoscale.InstanceType, error) {
+	v, err := env.instanceType.get(func() (interface{}, error) {
+		if env.instance.InstanceTypeID == nil {
+			return nil, nil
+		}
+
+		var instanceType *egoscale.InstanceType
+		err := env.call(func() (err error) {
+			instanceType, err = env.exo.GetInstanceType(env.ctx, *env.instance.Zone, *env.instance.InstanceTypeID)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve Instance Type %q: %w", *env.instance.InstanceTypeID, err)
+		}
+
+		return instanceType, nil
+	})
+	instanceType, _ := v.(*egoscale.InstanceType)
+
+	return instanceType, err
+}
+
+// getPrivateIPs returns the IP addresses leased to the instance on the
+// Private Networks it is attached to, indexed by Private Network name.
+func (env *roleValidatorEnv) getPrivateIPs() (map[string]string, error) {
+	v, err := env.privateIPs.get(func() (interface{}, error) {
+		if env.instance.PrivateNetworkIDs == nil {
+			return map[string]string{}, nil
+		}
+
+		ids := *env.instance.PrivateNetworkIDs
+		privateNetworks := make([]*egoscale.PrivateNetwork, len(ids))
+		err := env.parallel(len(ids), func(i int) error {
+			return env.call(func() (err error) {
+				privateNetworks[i], err = env.exo.GetPrivateNetwork(env.ctx, *env.instance.Zone, ids[i])
+				if err != nil {
+					return fmt.Errorf("unable to retrieve Private Network %q: %w", ids[i], err)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		privateIPs := make(map[string]string)
+		for _, privateNetwork := range privateNetworks {
+			// Only managed Private Networks have DHCP leases, the instance IP
+			// address on unmanaged Private Networks cannot be known.
+			for _, lease := range privateNetwork.Leases {
+				if lease.InstanceID != nil && *lease.InstanceID == *env.instance.ID && lease.IPAddress != nil {
+					privateIPs[*privateNetwork.Name] = ipString(lease.IPAddress)
+				}
+			}
+		}
+
+		return privateIPs, nil
+	})
+	privateIPs, _ := v.(map[string]string)
+
+	return privateIPs, err
+}
+
+// getSecurityGroups returns the Security Groups the instance belongs to.
+func (env *roleValidatorEnv) getSecurityGroups() ([]*egoscale.SecurityGroup, error) {
+	v, err := env.securityGroups.get(func() (interface{}, error) {
+		if env.instance.SecurityGroupIDs == nil {
+			return []*egoscale.SecurityGroup{}, nil
+		}
+
+		ids := *env.instance.SecurityGroupIDs
+		securityGroups := make([]*egoscale.SecurityGroup, len(ids))
+		err := env.parallel(len(ids), func(i int) error {
+			return env.call(func() (err error) {
+				securityGroups[i], err = env.exo.GetSecurityGroup(env.ctx, *env.instance.Zone, ids[i])
+				if err != nil {
+					return fmt.Errorf("unable to retrieve Security Group %q: %w", ids[i], err)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return securityGroups, nil
+	})
+	securityGroups, _ := v.([]*egoscale.SecurityGroup)
+
+	return securityGroups, err
+}
+
+// getSKSNodepool returns the SKS Nodepool the instance belongs to, or nil if
+// the instance is not an SKS node.
+func (env *roleValidatorEnv) getSKSNodepool() (*roleValidatorSKSNodepool, error) {
+	v, err := env.sksNodepool.get(func() (interface{}, error) {
+		var sksNodepool roleValidatorSKSNodepool
+		err := env.call(func() (err error) {
+			sksNodepool.cluster, sksNodepool.nodepool, err = instanceSKSNodepool(env.ctx, env.exo, env.instance)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sksNodepool.nodepool == nil {
+			return nil, nil
+		}
+
+		return &sksNodepool, nil
+	})
+	sksNodepool, _ := v.(*roleValidatorSKSNodepool)
+
+	return sksNodepool, err
+}
+
+// getSSHKey returns the SSH key the instance was created with, or nil if the
+// instance was created without SSH key or if the key has since been deleted.
+func (env *roleValidatorEnv) getSSHKey() (*egoscale.SSHKey, error) {
+	v, err := env.sshKey.get(func() (interface{}, error) {
+		if env.instance.SSHKey == nil {
+			return nil, nil
+		}
+
+		var sshKey *egoscale.SSHKey
+		err := env.call(func() (err error) {
+			sshKey, err = env.exo.GetSSHKey(env.ctx, *env.instance.Zone, *env.instance.SSHKey)
+			return err
+		})
+
+		// SSH keys can be deleted once instances have been created with them,
+		// in which case only the key name is known.
+		switch {
+		case err == nil:
+			return sshKey, nil
+
+		case errors.Is(err, exoapi.ErrNotFound):
+			return nil, nil
+
+		default:
+			return nil, fmt.Errorf("unable to retrieve SSH key %q: %w", *env.instance.SSHKey, err)
+		}
+	})
+	sshKey, _ := v.(*egoscale.SSHKey)
+
+	return sshKey, err
+}
+
+// getTemplate returns the template the instance was created from, or nil if
+// the template has since been deleted.
+func (env *roleValidatorEnv) getTemplate() (*egoscale.Template, error) {
+	v, err := env.template.get(func() (interface{}, error) {
+		if env.instance.TemplateID == nil {
+			return nil, nil
+		}
+
+		var template *egoscale.Template
+		err := env.call(func() (err error) {
+			template, err = env.exo.GetTemplate(env.ctx, *env.instance.Zone, *env.instance.TemplateID)
+			return err
+		})
+
+		// Private templates can be deleted while instances created from them
+		// are still running, in which case only the template ID is known.
+		switch {
+		case err == nil:
+			return template, nil
+
+		case errors.Is(err, exoapi.ErrNotFound):
+			return nil, nil
+
+		default:
+			return nil, fmt.Errorf("unable to retrieve Template %q: %w", *env.instance.TemplateID, err)
+		}
+	})
+	template, _ := v.(*egoscale.Template)
+
+	return template, err
+}
+
+// denials returns the top-level conjuncts of the role validator expression
+// which didn't evaluate to true according to the evaluation state, along with
+// their value (e.g. "client_ip == instance_public_ip: false").
+func (p *roleValidatorProgram) denials(state interpreter.EvalState) []string {
+	var (
+		denials   = make([]string, 0)
+		conjuncts func(*exprpb.Expr) []*exprpb.Expr
+	)
+
+	conjuncts = func(e *exprpb.Expr) []*exprpb.Expr {
+		if call := e.GetCallExpr(); call != nil && call.Function == operators.LogicalAnd {
+			return append(conjuncts(call.Args[0]), conjuncts(call.Args[1])...)
+		}
+		return []*exprpb.Expr{e}
+	}
+
+	for _, conjunct := range conjuncts(celDesugarMacros(p.ast.Expr(), make(map[int64]struct{}))) {
+		v, ok := state.Value(conjunct.Id)
+		if !ok || v == types.True {
+			continue
+		}
+
+		expression, err := parser.Unparse(conjunct, p.ast.SourceInfo())
+		if err != nil {
+			expression = fmt.Sprintf("<subexpression #%d>", conjunct.Id)
+		}
+		denials = append(denials, fmt.Sprintf("%s: %v", expression, celTraceValue(v)))
+	}
+
+	return denials
+}
+
+// trace returns the values of the subexpressions of the role validator
+// expression recorded in the evaluation state, from the outermost to the
+// innermost subexpression. The arguments of macros (e.g. exists()) are
+// evaluated for each element of their target, so only the overall result of
+// macros is reported.
+func (p *roleValidatorProgram) trace(state interpreter.EvalState) []map[string]interface{} {
+	var (
+		macros = make(map[int64]struct{})
+		trace  = make([]map[string]interface{}, 0)
+		walk   func(*exprpb.Expr)
+	)
+
+	walk = func(e *exprpb.Expr) {
+		if e.GetConstExpr() == nil {
+			if v, ok := state.Value(e.Id); ok {
+				if expression, err := parser.Unparse(e, p.ast.SourceInfo()); err == nil {
+					trace = append(trace, map[string]interface{}{
+						"expression": expression,
+						"value":      celTraceValue(v),
+					})
+				}
+			}
+		}
+
+		switch k := e.ExprKind.(type) {
+		case *exprpb.Expr_CallExpr:
+			if k.CallExpr.Target != nil {
+				walk(k.CallExpr.Target)
+			}
+			if _, ok := macros[e.Id]; ok {
+				return
+			}
+			for _, arg := range k.CallExpr.Args {
+				walk(arg)
+			}
+
+		case *exprpb.Expr_SelectExpr:
+			walk(k.SelectExpr.Operand)
+
+		case *exprpb.Expr_ListExpr:
+			for _, element := range k.ListExpr.Elements {
+				walk(element)
+			}
+
+		default:
+		}
+	}
+	walk(celDesugarMacros(p.ast.Expr(), macros))
+
+	return trace
+}
+
+// celDesugarMacros returns a copy of expr in which the comprehensions expanded
+// from the CEL macros (all, exists, exists_one, filter, map) are replaced by
+// the macro calls they have been expanded from, so that the expression can be
+// unparsed. The IDs of the replaced comprehensions are recorded in macros.
+func celDesugarMacros(expr *exprpb.Expr, macros map[int64]struct{}) *exprpb.Expr {
+	out := &exprpb.Expr{Id: expr.Id, ExprKind: expr.ExprKind}
+
+	desugarCall := func(call *exprpb.Expr_Call) *exprpb.Expr_CallExpr {
+		desugared := &exprpb.Expr_Call{Function: call.Function}
+		if call.Target != nil {
+			desugared.Target = celDesugarMacros(call.Target, macros)
+		}
+		for _, arg := range call.Args {
+			desugared.Args = append(desugared.Args, celDesugarMacros(arg, macros))
+		}
+		return &exprpb.Expr_CallExpr{CallExpr: desugared}
+	}
+
+	switch k := expr.ExprKind.(type) {
+	case *exprpb.Expr_ComprehensionExpr:
+		if call := celMacroCall(k.ComprehensionExpr); call != nil {
+			macros[expr.Id] = struct{}{}
+			out.ExprKind = desugarCall(call)
+		}
+
+	case *exprpb.Expr_CallExpr:
+		out.ExprKind = desugarCall(k.CallExpr)
+
+	case *exprpb.Expr_SelectExpr:
+		out.ExprKind = &exprpb.Expr_SelectExpr{SelectExpr: &exprpb.Expr_Select{
+			Operand:  celDesugarMacros(k.SelectExpr.Operand, macros),
+			Field:    k.SelectExpr.Field,
+			TestOnly: k.SelectExpr.TestOnly,
+		}}
+
+	case *exprpb.Expr_ListExpr:
+		elements := make([]*exprpb.Expr, 0, len(k.ListExpr.Elements))
+		for _, element := range k.ListExpr.Elements {
+			elements = append(elements, celDesugarMacros(element, macros))
+		}
+		out.ExprKind = &exprpb.Expr_ListExpr{ListExpr: &exprpb.Expr_CreateList{Elements: elements}}
+
+	default:
+	}
+
+	return out
+}
+
+// celMacroCall returns the macro call the comprehension has been expanded
+// from, or nil if the comprehension doesn't match any known macro expansion.
+func celMacroCall(c *exprpb.Expr_Comprehension) *exprpb.Expr_Call {
+	step := c.LoopStep.GetCallExpr()
+	if step == nil || len(step.Args) < 2 {
+		return nil
+	}
+
+	iterVar := &exprpb.Expr{ExprKind: &exprpb.Expr_IdentExpr{IdentExpr: &exprpb.Expr_Ident{Name: c.IterVar}}}
+	call := &exprpb.Expr_Call{Target: c.IterRange}
+
+	switch {
+	case c.AccuInit.GetConstExpr() != nil && step.Function == operators.LogicalAnd:
+		call.Function, call.Args = "all", []*exprpb.Expr{iterVar, step.Args[1]}
+
+	case c.AccuInit.GetConstExpr() != nil && step.Function == operators.LogicalOr:
+		call.Function, call.Args = "exists", []*exprpb.Expr{iterVar, step.Args[1]}
+
+	case c.AccuInit.GetConstExpr() != nil && step.Function == operators.Conditional:
+		call.Function, call.Args = "exists_one", []*exprpb.Expr{iterVar, step.Args[0]}
+
+	case c.AccuInit.GetListExpr() != nil && step.Function == operators.Add:
+		elements := step.Args[1].GetListExpr().GetElements()
+		if len(elements) != 1 {
+			return nil
+		}
+		call.Function, call.Args = "map", []*exprpb.Expr{iterVar, elements[0]}
+
+	case c.AccuInit.GetListExpr() != nil && step.Function == operators.Conditional:
+		add := step.Args[1].GetCallExpr()
+		if add == nil || len(add.Args) != 2 || len(add.Args[1].GetListExpr().GetElements()) != 1 {
+			return nil
+		}
+		element := add.Args[1].GetListExpr().GetElements()[0]
+		if element.GetIdentExpr().GetName() == c.IterVar {
+			call.Function, call.Args = "filter", []*exprpb.Expr{iterVar, step.Args[0]}
+		} else {
+			call.Function, call.Args = "map", []*exprpb.Expr{iterVar, step.Args[0], element}
+		}
+
+	default:
+		return nil
+	}
+
+	return call
+}
+
+// celTraceValue returns a representation of a CEL value suitable for
+// inclusion in API responses.
+func celTraceValue(v ref.Val) interface{} {
+	switch v := v.(type) {
+	case *types.Err:
+		return "error: " + v.Error()
+
+	case types.Unknown:
+		return "unknown"
+
+	case types.Duration:
+		return v.Duration.String()
+
+	case celIP:
+		return v.IP.String()
+
+	case celCIDR:
+		return v.IPNet.String()
+
+	case traits.Lister:
+		list := make([]interface{}, 0)
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			list = append(list, celTraceValue(it.Next()))
+		}
+		return list
+
+	case traits.Mapper:
+		m := make(map[string]interface{})
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			k := it.Next()
+			m[fmt.Sprint(celTraceValue(k))] = celTraceValue(v.Get(k))
+		}
+		return m
+
+	default:
+		return v.Value()
+	}
+}
+
+// stringValue returns the string pointed to by s, or an empty string if s is
+// not set.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// stringMapValue returns a copy of the map pointed to by m, or an empty map if
+// m is not set.
+func stringMapValue(m *map[string]string) map[string]string {
+	out := make(map[string]string)
+	if m != nil {
+		for k, v := range *m {
+			out[k] = v
+		}
+	}
+
+	return out
+}
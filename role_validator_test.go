@@ -0,0 +1,138 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+func (ts *backendTestSuite) TestRoleValidatorEnvConcurrency() {
+	var (
+		concurrency = 2
+		inFlight    int32
+		maxInFlight int32
+		sgIDs       = make([]string, 6)
+	)
+
+	exo := new(exoscaleClientMock)
+	for i := range sgIDs {
+		id := ts.randomID()
+		sgIDs[i] = id
+		exo.
+			On("GetSecurityGroup", mock.Anything, testZone, id).
+			Run(func(_ mock.Arguments) {
+				n := atomic.AddInt32(&inFlight, 1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+			}).
+			Return(&egoscale.SecurityGroup{ID: &sgIDs[i], Name: &sgIDs[i]}, nil)
+	}
+
+	instance := &egoscale.Instance{
+		ID:               &testInstanceID,
+		SecurityGroupIDs: &sgIDs,
+		Zone:             &testZone,
+	}
+
+	env := newRoleValidatorEnv(context.Background(), exo, testInstanceIPAddress.String(), instance, nil, concurrency)
+	defer env.close()
+
+	securityGroups, err := env.getSecurityGroups()
+	ts.Require().NoError(err)
+	ts.Require().Len(securityGroups, len(sgIDs))
+	for i, securityGroup := range securityGroups {
+		ts.Require().Equal(sgIDs[i], *securityGroup.ID)
+	}
+	ts.Require().Equal(int32(concurrency), atomic.LoadInt32(&maxInFlight))
+}
+
+func (ts *backendTestSuite) TestRoleValidatorEnvError() {
+	var (
+		testError    = errors.New("internal server error")
+		failingSGID  = ts.randomID()
+		blockingSGID = ts.randomID()
+	)
+
+	exo := new(exoscaleClientMock)
+	exo.
+		On("GetSecurityGroup", mock.Anything, testZone, failingSGID).
+		Return((*egoscale.SecurityGroup)(nil), testError)
+	exo.
+		On("GetSecurityGroup", mock.Anything, testZone, blockingSGID).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return((*egoscale.SecurityGroup)(nil), context.Canceled)
+	exo.
+		On("GetInstancePool", mock.Anything, testZone, testInstancePoolID).
+		Return(&egoscale.InstancePool{ID: &testInstancePoolID, Name: &testInstancePoolName}, nil)
+
+	instance := &egoscale.Instance{
+		ID:               &testInstanceID,
+		Manager:          &egoscale.InstanceManager{ID: testInstancePoolID, Type: "instance-pool"},
+		SecurityGroupIDs: &[]string{blockingSGID, failingSGID},
+		State:            &testInstanceState,
+		Zone:             &testZone,
+	}
+
+	p, err := buildCELProgram(roleValidatorVarInstanceManagerName + ` == "` + testInstancePoolName + `" && ` +
+		`"web" in ` + roleValidatorVarInstanceSecurityGroupNames)
+	ts.Require().NoError(err)
+
+	env := newRoleValidatorEnv(context.Background(), exo, testInstanceIPAddress.String(), instance, nil, 4)
+	defer env.close()
+
+	// The failing lookup cancels the pending ones, and its error is reported
+	// rather than the resulting cancellation errors.
+	_, err = p.eval(env)
+	ts.Require().Error(err)
+	ts.Require().True(errors.Is(err, testError))
+	ts.Require().Contains(err.Error(), failingSGID)
+}
+
+func (ts *backendTestSuite) TestRoleValidatorDenials() {
+	instance := &egoscale.Instance{
+		ID:              &testInstanceID,
+		Labels:          &testInstanceLabels,
+		PublicIPAddress: &testInstanceIPAddress,
+		State:           &testInstanceState,
+		Zone:            &testZone,
+	}
+
+	role := &backendRole{
+		Validator: roleValidatorVarInstanceState + ` == "` + instanceStateRunning + `" && ` +
+			roleValidatorVarClientIP + " == " + roleValidatorVarInstancePublicIP + " && " +
+			roleValidatorVarInstanceLabels + `.exists(k, k == "nope")`,
+	}
+
+	err := ts.backend.(*exoscaleBackend).checkInstanceRole(
+		context.Background(),
+		new(exoscaleClientMock),
+		testOtherInstanceIPAddress.String(),
+		instance,
+		nil,
+		testRoleName,
+		role,
+		defaultAPIConcurrency,
+	)
+	ts.Require().True(errors.Is(err, errAuthFailed))
+
+	var validationErr *roleValidationError
+	ts.Require().True(errors.As(err, &validationErr))
+	ts.Require().Equal([]string{
+		roleValidatorVarClientIP + " == " + roleValidatorVarInstancePublicIP + ": false",
+		roleValidatorVarInstanceLabels + `.exists(k, k == "nope"): false`,
+	}, validationErr.denials)
+	ts.Require().NotContains(err.Error(), validationErr.denials[0])
+}
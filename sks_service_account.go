@@ -0,0 +1,341 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+const (
+	sksClusterKeysCacheTTL = 5 * time.Minute
+	// sksClusterKeysMinRefreshInterval is the minimum interval between two
+	// fetches of the keys of a cluster triggered by tokens bearing unknown key
+	// IDs, which are chosen by (unauthenticated) clients.
+	sksClusterKeysMinRefreshInterval = 30 * time.Second
+	sksClusterRequestTimeout         = 10 * time.Second
+
+	sksServiceAccountSubjectPrefix = "system:serviceaccount:"
+)
+
+// sksServiceAccount represents a Kubernetes service account authenticated
+// using a token issued by an SKS cluster.
+type sksServiceAccount struct {
+	Namespace string
+	Name      string
+}
+
+// sksClusterKeys represents the service account token issuer and signing keys
+// of an SKS cluster.
+type sksClusterKeys struct {
+	issuer  string
+	keys    *jose.JSONWebKeySet
+	fetched time.Time
+}
+
+// sksClusterKeysCacheEntry holds the cached keys of an SKS cluster. Its lock
+// serializes the fetches of the keys of this cluster only.
+type sksClusterKeysCacheEntry struct {
+	sync.Mutex
+	keys        *sksClusterKeys
+	lastFetchAt time.Time
+}
+
+type sksServiceAccountClaims struct {
+	jwt.Claims
+
+	Kubernetes *struct {
+		Node *struct {
+			Name string `json:"name"`
+		} `json:"node,omitempty"`
+	} `json:"kubernetes.io,omitempty"`
+}
+
+// checkSKSNodepoolMembership verifies that the instance is a member of one of
+// the Nodepools of the specified SKS cluster.
+func checkSKSNodepoolMembership(instance *egoscale.Instance, cluster *egoscale.SKSCluster) error {
+	for _, nodepool := range cluster.Nodepools {
+		if sksNodepoolHasInstance(nodepool, instance) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: instance %s is not a member of SKS cluster %s",
+		errAuthFailed,
+		*instance.ID,
+		*cluster.ID)
+}
+
+// sksNodepoolHasInstance reports whether the instance is a member of the SKS
+// Nodepool, i.e. whether it is managed by the Nodepool itself or by the
+// Instance Pool backing the Nodepool.
+func sksNodepoolHasInstance(nodepool *egoscale.SKSNodepool, instance *egoscale.Instance) bool {
+	if instance.Manager == nil {
+		return false
+	}
+
+	switch instance.Manager.Type {
+	case "instance-pool":
+		return nodepool.InstancePoolID != nil && *nodepool.InstancePoolID == instance.Manager.ID
+
+	case "sks-nodepool":
+		return nodepool.ID != nil && *nodepool.ID == instance.Manager.ID
+
+	default:
+		return false
+	}
+}
+
+// instanceSKSNodepool returns the SKS Nodepool the instance is a member of,
+// along with the SKS cluster it belongs to, or nil if the instance is not an
+// SKS node.
+func instanceSKSNodepool(
+	ctx context.Context,
+	exo exoscaleClient,
+	instance *egoscale.Instance,
+) (*egoscale.SKSCluster, *egoscale.SKSNodepool, error) {
+	if instance.Manager == nil {
+		return nil, nil, nil
+	}
+
+	clusters, err := exo.ListSKSClusters(ctx, *instance.Zone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to list SKS clusters: %w", err)
+	}
+
+	for _, cluster := range clusters {
+		for _, nodepool := range cluster.Nodepools {
+			if sksNodepoolHasInstance(nodepool, instance) {
+				return cluster, nodepool, nil
+			}
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// checkSKSServiceAccount verifies that token is a valid service account
+// token issued by the SKS cluster the role is bound to, and that the instance
+// presenting it is a node of this cluster.
+func (b *exoscaleBackend) checkSKSServiceAccount(
+	ctx context.Context,
+	exo exoscaleClient,
+	role *backendRole,
+	instance *egoscale.Instance,
+	token string,
+) (*sksServiceAccount, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: %s", errMissingField, authLoginParamServiceAccountToken)
+	}
+
+	cluster, err := exo.GetSKSCluster(ctx, *instance.Zone, role.SKSClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to retrieve SKS cluster %q: %v", // nolint:errorlint
+			errInternalError,
+			role.SKSClusterID,
+			err)
+	}
+
+	if err := checkSKSNodepoolMembership(instance, cluster); err != nil {
+		return nil, err
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errInvalidFieldValue, authLoginParamServiceAccountToken, err) // nolint:errorlint
+	}
+
+	var kid string
+	for _, h := range parsed.Headers {
+		if h.KeyID != "" {
+			kid = h.KeyID
+			break
+		}
+	}
+
+	keys, err := b.sksClusterKeys(ctx, exo, *instance.Zone, cluster, token, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims sksServiceAccountClaims
+	if err := parsed.Claims(keys.keys, &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid service account token signature: %v", errAuthFailed, err) // nolint:errorlint
+	}
+
+	expected := jwt.Expected{Issuer: keys.issuer, Time: time.Now()}
+	if role.SKSAudience != "" {
+		expected.Audience = jwt.Audience{role.SKSAudience}
+	}
+	if err := claims.Validate(expected); err != nil {
+		return nil, fmt.Errorf("%w: invalid service account token: %v", errAuthFailed, err) // nolint:errorlint
+	}
+
+	if !strings.HasPrefix(claims.Subject, sksServiceAccountSubjectPrefix) {
+		return nil, fmt.Errorf("%w: token subject %q is not a service account", errAuthFailed, claims.Subject)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(claims.Subject, sksServiceAccountSubjectPrefix), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: invalid service account token subject %q", errAuthFailed, claims.Subject)
+	}
+
+	// Tokens bound to a Pod carry the name of the Node it runs on: if present,
+	// it must match the instance presenting the token.
+	if claims.Kubernetes != nil && claims.Kubernetes.Node != nil &&
+		(instance.Name == nil || claims.Kubernetes.Node.Name != *instance.Name) {
+		return nil, fmt.Errorf("%w: service account token is bound to another node (%s)",
+			errAuthFailed,
+			claims.Kubernetes.Node.Name)
+	}
+
+	return &sksServiceAccount{Namespace: parts[0], Name: parts[1]}, nil
+}
+
+// sksClusterKeys returns the service account token issuer and signing keys of
+// the specified SKS cluster, retrieved from the cluster control plane OIDC
+// discovery endpoints. Since those endpoints require authentication, the
+// service account token supplied by the client is used to access them.
+// Results are cached, and refreshed if the key kid is unknown (at most once
+// every sksClusterKeysMinRefreshInterval).
+func (b *exoscaleBackend) sksClusterKeys(
+	ctx context.Context,
+	exo exoscaleClient,
+	zone string,
+	cluster *egoscale.SKSCluster,
+	token string,
+	kid string,
+) (*sksClusterKeys, error) {
+	// The backend-wide lock only guards the cache map: keys are fetched while
+	// holding the lock of the cluster entry, so that fetching the keys of a
+	// cluster doesn't hold up the logins bound to other clusters.
+	b.sksKeysLock.Lock()
+	if b.sksKeysCache == nil {
+		b.sksKeysCache = make(map[string]*sksClusterKeysCacheEntry)
+	}
+	entry, ok := b.sksKeysCache[*cluster.ID]
+	if !ok {
+		entry = &sksClusterKeysCacheEntry{}
+		b.sksKeysCache[*cluster.ID] = entry
+	}
+	b.sksKeysLock.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+
+	if keys := entry.keys; keys != nil && time.Since(keys.fetched) < sksClusterKeysCacheTTL &&
+		(kid == "" || len(keys.keys.Key(kid)) > 0 ||
+			time.Since(entry.lastFetchAt) < sksClusterKeysMinRefreshInterval) {
+		return keys, nil
+	}
+
+	entry.lastFetchAt = time.Now()
+
+	keys, err := fetchSKSClusterKeys(ctx, exo, zone, cluster, token)
+	if err != nil {
+		return nil, err
+	}
+	entry.keys = keys
+
+	return keys, nil
+}
+
+// fetchSKSClusterKeys retrieves the service account token issuer and signing
+// keys of the specified SKS cluster from its control plane.
+func fetchSKSClusterKeys(
+	ctx context.Context,
+	exo exoscaleClient,
+	zone string,
+	cluster *egoscale.SKSCluster,
+	token string,
+) (*sksClusterKeys, error) {
+	if cluster.Endpoint == nil {
+		return nil, fmt.Errorf("%w: SKS cluster %s has no endpoint", errInternalError, *cluster.ID)
+	}
+
+	caCert, err := exo.GetSKSClusterAuthorityCert(ctx, zone, cluster, "control-plane")
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to retrieve SKS cluster %s CA certificate: %v", // nolint:errorlint
+			errInternalError,
+			*cluster.ID,
+			err)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(caCert); err == nil {
+		caCert = string(decoded)
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, fmt.Errorf("%w: invalid SKS cluster %s CA certificate", errInternalError, *cluster.ID)
+	}
+
+	client := &http.Client{
+		Timeout: sksClusterRequestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: caPool, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	endpoint := strings.TrimSuffix(*cluster.Endpoint, "/")
+
+	var discovery struct {
+		Issuer string `json:"issuer"`
+	}
+	if err := sksClusterGet(ctx, client, endpoint+"/.well-known/openid-configuration", token, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer == "" {
+		return nil, fmt.Errorf("%w: SKS cluster %s advertises no service account issuer",
+			errInternalError,
+			*cluster.ID)
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := sksClusterGet(ctx, client, endpoint+"/openid/v1/jwks", token, &jwks); err != nil {
+		return nil, err
+	}
+
+	return &sksClusterKeys{
+		issuer:  discovery.Issuer,
+		keys:    &jwks,
+		fetched: time.Now(),
+	}, nil
+}
+
+func sksClusterGet(ctx context.Context, client *http.Client, url, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInternalError, err) // nolint:errorlint
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: unable to query SKS cluster: %v", errInternalError, err) // nolint:errorlint
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized, res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: service account token rejected by SKS cluster (%s)", errAuthFailed, res.Status)
+
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("%w: unexpected SKS cluster response status %s", errInternalError, res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: unable to decode SKS cluster response: %v", errInternalError, err) // nolint:errorlint
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package exoscale
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+var (
+	testSKSClusterID         = new(backendTestSuite).randomID()
+	testSKSClusterName       = new(backendTestSuite).randomString(10)
+	testSKSIssuer            = "https://kubernetes.default.svc.cluster.local"
+	testSKSNodepoolID        = new(backendTestSuite).randomID()
+	testSKSNodepoolName      = new(backendTestSuite).randomString(10)
+	testSKSServiceAccountNS  = "default"
+	testSKSServiceAccountSA  = "app"
+	testSKSServiceAccountKID = "test"
+)
+
+func (ts *backendTestSuite) TestPathLoginSKS() {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ts.Require().NoError(err)
+	otherSigningKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ts.Require().NoError(err)
+
+	// Stand-in SKS cluster control plane, serving the OIDC discovery endpoints.
+	controlPlane := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": testSKSIssuer})
+
+		case "/openid/v1/jwks":
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+				Key:       &signingKey.PublicKey,
+				KeyID:     testSKSServiceAccountKID,
+				Algorithm: string(jose.RS256),
+				Use:       "sig",
+			}}})
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer controlPlane.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: controlPlane.Certificate().Raw,
+	}))
+
+	token := func(key *rsa.PrivateKey, subject string) string {
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.RS256, Key: key},
+			(&jose.SignerOptions{}).WithHeader("kid", testSKSServiceAccountKID),
+		)
+		ts.Require().NoError(err)
+
+		raw, err := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   testSKSIssuer,
+			Subject:  subject,
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		}).CompactSerialize()
+		ts.Require().NoError(err)
+
+		return raw
+	}
+
+	subject := sksServiceAccountSubjectPrefix + testSKSServiceAccountNS + ":" + testSKSServiceAccountSA
+
+	tests := []struct {
+		name         string
+		resCheckFunc func(*backendTestSuite, *logical.Response, error)
+		token        string
+		wantErr      bool
+	}{
+		{
+			name: "fail_missing_token",
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, _ error) {
+				ts.Require().Contains(res.Error().Error(), errMissingField.Error())
+			},
+		},
+		{
+			name: "fail_bad_signature",
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			token:   token(otherSigningKey, subject),
+			wantErr: true,
+		},
+		{
+			name: "fail_validator",
+			resCheckFunc: func(ts *backendTestSuite, _ *logical.Response, err error) {
+				ts.Require().EqualError(err, logical.ErrPermissionDenied.Error())
+			},
+			token:   token(signingKey, sksServiceAccountSubjectPrefix+"kube-system:admin"),
+			wantErr: true,
+		},
+		{
+			name: "ok",
+			resCheckFunc: func(ts *backendTestSuite, res *logical.Response, err error) {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testSKSServiceAccountNS, res.Auth.InternalData["sa_namespace"])
+				ts.Require().Equal(testSKSServiceAccountSA, res.Auth.InternalData["sa_name"])
+			},
+			token: token(signingKey, subject),
+		},
+	}
+
+	ts.storeEntry(configStoragePath, &backendConfig{Zone: testZone})
+	ts.storeEntry(roleStoragePathPrefix+testRoleName, backendRole{
+		Validator: `sa_namespace == "` + testSKSServiceAccountNS + `" && sa_name == "` + testSKSServiceAccountSA + `" && ` +
+			`instance_sks_cluster_id == "` + testSKSClusterID + `" && ` +
+			`instance_sks_cluster_name == "` + testSKSClusterName + `" && ` +
+			`instance_sks_nodepool_name == "` + testSKSNodepoolName + `"`,
+		SKSClusterID: testSKSClusterID,
+	})
+
+	cluster := &egoscale.SKSCluster{
+		Endpoint: &controlPlane.URL,
+		ID:       &testSKSClusterID,
+		Name:     &testSKSClusterName,
+		Nodepools: []*egoscale.SKSNodepool{{
+			ID:             &testSKSNodepoolID,
+			InstancePoolID: &testInstancePoolID,
+			Name:           &testSKSNodepoolName,
+		}},
+	}
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetInstance", mock.Anything, testZone, testInstanceID).
+		Return(&egoscale.Instance{
+			CreatedAt: &testInstanceCreated,
+			ID:        &testInstanceID,
+			Manager: &egoscale.InstanceManager{
+				ID:   testInstancePoolID,
+				Type: "instance-pool",
+			},
+			Name:            &testInstanceName,
+			PublicIPAddress: &testInstanceIPAddress,
+			State:           &testInstanceState,
+			Zone:            &testZone,
+		}, nil)
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("ListSKSClusters", mock.Anything, testZone).
+		Return([]*egoscale.SKSCluster{cluster}, nil)
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetSKSCluster", mock.Anything, testZone, testSKSClusterID).
+		Return(cluster, nil)
+
+	ts.backend.(*exoscaleBackend).exo.(*exoscaleClientMock).
+		On("GetSKSClusterAuthorityCert", mock.Anything, testZone, cluster, "control-plane").
+		Return(caCert, nil)
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{
+				authLoginParamInstance: testInstanceID,
+				authLoginParamRole:     testRoleName,
+			}
+			if tt.token != "" {
+				data[authLoginParamServiceAccountToken] = tt.token
+			}
+
+			res, err := ts.backend.HandleRequest(context.Background(), &logical.Request{
+				Storage:    ts.storage,
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Connection: &logical.Connection{RemoteAddr: testInstanceIPAddress.String()},
+				Data:       data,
+			})
+			if err != nil != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			tt.resCheckFunc(ts, res, err)
+		})
+	}
+}
+
+func (ts *backendTestSuite) TestCheckSKSNodepoolMembership() {
+	cluster := &egoscale.SKSCluster{
+		ID: &testSKSClusterID,
+		Nodepools: []*egoscale.SKSNodepool{{
+			ID:             &testSKSNodepoolID,
+			InstancePoolID: &testInstancePoolID,
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		manager *egoscale.InstanceManager
+		wantErr bool
+	}{
+		{
+			name:    "fail_no_manager",
+			wantErr: true,
+		},
+		{
+			name:    "fail_other_instance_pool",
+			manager: &egoscale.InstanceManager{ID: ts.randomID(), Type: "instance-pool"},
+			wantErr: true,
+		},
+		{
+			name:    "ok_instance_pool",
+			manager: &egoscale.InstanceManager{ID: testInstancePoolID, Type: "instance-pool"},
+		},
+		{
+			name:    "ok_sks_nodepool",
+			manager: &egoscale.InstanceManager{ID: testSKSNodepoolID, Type: "sks-nodepool"},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			err := checkSKSNodepoolMembership(&egoscale.Instance{ID: &testInstanceID, Manager: tt.manager}, cluster)
+			if err != nil != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func (ts *backendTestSuite) TestSKSClusterKeysRefresh() {
+	var fetches int32
+
+	controlPlane := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": testSKSIssuer})
+
+		case "/openid/v1/jwks":
+			atomic.AddInt32(&fetches, 1)
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{})
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer controlPlane.Close()
+
+	cluster := &egoscale.SKSCluster{Endpoint: &controlPlane.URL, ID: &testSKSClusterID}
+
+	exo := new(exoscaleClientMock)
+	exo.
+		On("GetSKSClusterAuthorityCert", mock.Anything, testZone, cluster, "control-plane").
+		Return(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: controlPlane.Certificate().Raw,
+		})), nil)
+
+	b := ts.backend.(*exoscaleBackend)
+
+	_, err := b.sksClusterKeys(context.Background(), exo, testZone, cluster, "token", "")
+	ts.Require().NoError(err)
+	ts.Require().Equal(int32(1), atomic.LoadInt32(&fetches))
+
+	// Tokens bearing unknown key IDs don't trigger a refresh more than once
+	// every sksClusterKeysMinRefreshInterval.
+	for i := 0; i < 3; i++ {
+		_, err = b.sksClusterKeys(context.Background(), exo, testZone, cluster, "token", ts.randomString(8))
+		ts.Require().NoError(err)
+	}
+	ts.Require().Equal(int32(1), atomic.LoadInt32(&fetches))
+
+	b.sksKeysCache[testSKSClusterID].lastFetchAt = time.Now().Add(-sksClusterKeysMinRefreshInterval)
+	_, err = b.sksClusterKeys(context.Background(), exo, testZone, cluster, "token", ts.randomString(8))
+	ts.Require().NoError(err)
+	ts.Require().Equal(int32(2), atomic.LoadInt32(&fetches))
+}